@@ -17,22 +17,56 @@ type App struct {
 	ctx          context.Context
 	cfg          *converter.Config
 	pendingFiles []string
-	mu           sync.Mutex
-	destMu       sync.Mutex
-	isReady      bool
-	processTimer *time.Timer
-	jobCancels   map[string]context.CancelFunc
-	isPaused     bool
-	pauseCond    *sync.Cond
-	ffmpegSem    chan struct{}
-	magickSem    chan struct{}
+	// launchOptions holds options given on the command line, keyed by the
+	// absolute file path, until the frontend submits the file.
+	launchOptions map[string]JobOptions
+	mu            sync.Mutex
+	destMu        sync.Mutex
+	isReady       bool
+	processTimer  *time.Timer
+	jobCancels    map[string]context.CancelFunc
+	// jobControls suspends and resumes the processes of running jobs.
+	jobControls map[string]*converter.Control
+	sched       *scheduler
+	// workers limits the ffmpeg, magick and thumbnail work that runs at once.
+	workers *workerPool
+	// manifest records finished conversions for skip-if-identical.
+	manifest *manifest
+	// cache maps source contents to outputs, to skip re-encoding.
+	cache *contentCache
+	// reserved holds output paths claimed by running jobs, guarded by destMu.
+	reserved map[string]struct{}
+	parts    *partJournal
+	// jobs holds the status of every job by ID, jobOrder the queue order.
+	jobs     map[string]*JobStatus
+	jobOrder []string
+	// batches tracks the jobs of each ConvertFiles call by batch ID.
+	batches map[string]*batch
+	// folderScans holds the files of folder scans awaiting confirmation.
+	folderScans map[string][]ConvertRequest
+	// jobsWG tracks the goroutines of queued and running jobs.
+	jobsWG sync.WaitGroup
+	// closing is set once the window may close; no new jobs are accepted.
+	closing bool
+	// queuePath is where unfinished jobs are saved when closing.
+	queuePath string
 }
 
 func NewApp() *App {
 	app := &App{
-		jobCancels: make(map[string]context.CancelFunc),
+		jobCancels:    make(map[string]context.CancelFunc),
+		jobControls:   make(map[string]*converter.Control),
+		launchOptions: make(map[string]JobOptions),
+		manifest:      newManifest(""),
+		cache:         newContentCache(""),
+		reserved:      make(map[string]struct{}),
+		parts:         newPartJournal(""),
+		jobs:          make(map[string]*JobStatus),
+		batches:       make(map[string]*batch),
+		folderScans:   make(map[string][]ConvertRequest),
 	}
-	app.pauseCond = sync.NewCond(&app.mu)
+	app.workers = newWorkerPool()
+	app.sched = newScheduler(app.workers.tryAcquire)
 	return app
 }
 
@@ -42,6 +76,7 @@ func (a *App) startup(ctx context.Context) {
 
 	runtime.EventsOn(ctx, "frontend-ready", func(optionalData ...interface{}) {
 		logger.Info("Frontend reported ready")
+		a.restoreQueue()
 		a.processPendingFiles()
 	})
 }
@@ -54,11 +89,35 @@ func (a *App) domReady(ctx context.Context) {
 	logger.Info("DOM is ready.")
 }
 
+// beforeClose keeps the window open while jobs are active and asks the
+// frontend what to do with them through a "confirm-close" event, which is
+// answered with ConfirmClose.
 func (a *App) beforeClose(ctx context.Context) (prevent bool) {
-	return false
+	a.mu.Lock()
+	closing := a.closing
+	a.mu.Unlock()
+	if closing {
+		return false
+	}
+
+	active := a.activeJobs()
+	if active.Running == 0 && active.Waiting == 0 {
+		return false
+	}
+	runtime.EventsEmit(ctx, "confirm-close", active)
+	return true
 }
 
+// shutdown cancels whatever is still running, so that no converter process
+// outlives the app and no part file is left behind.
 func (a *App) shutdown(ctx context.Context) {
+	a.setClosing()
+	a.cancelJobs(false)
+	if !a.waitForJobs(shutdownTimeout) {
+		logger.Warn("Jobs did not stop before shutdown")
+	}
+	a.manifest.flush()
+	a.cache.flush()
 }
 
 func (a *App) OnSecondInstanceLaunch(secondInstanceData options.SecondInstanceData) {
@@ -69,11 +128,18 @@ func (a *App) OnSecondInstanceLaunch(secondInstanceData options.SecondInstanceDa
 		logger.Error("Error getting executable path during second instance launch", "error", err)
 	}
 
+	requests, err := parseLaunchArgs(secondInstanceData.Args)
+	if err != nil {
+		logger.Error("Skipping invalid second instance options", "args", secondInstanceData.Args, "error", err)
+	}
+
 	// Pre-process files outside the lock to avoid blocking UI during IO
 	var actualFiles []string
-	if len(secondInstanceData.Args) > 0 {
-		logger.Info("Processing second instance args", "count", len(secondInstanceData.Args))
-		for _, arg := range secondInstanceData.Args {
+	actualOptions := make(map[string]JobOptions)
+	if len(requests) > 0 {
+		logger.Info("Processing second instance args", "count", len(requests))
+		for _, req := range requests {
+			arg := req.File
 			if exePath != "" {
 				if absArg, err := filepath.Abs(arg); err == nil && strings.EqualFold(absArg, exePath) {
 					logger.Info("Skipping executable path in args", "arg", arg)
@@ -81,12 +147,12 @@ func (a *App) OnSecondInstanceLaunch(secondInstanceData options.SecondInstanceDa
 				}
 			}
 
-			if info, err := os.Stat(arg); err == nil && !info.IsDir() && info.Size() > 0 {
+			if info, err := os.Stat(arg); err == nil && (info.IsDir() || info.Size() > 0) {
 				if absArg, err := filepath.Abs(arg); err == nil {
-					actualFiles = append(actualFiles, absArg)
-				} else {
-					actualFiles = append(actualFiles, arg)
+					arg = absArg
 				}
+				actualFiles = append(actualFiles, arg)
+				actualOptions[arg] = req.Options
 			} else {
 				logger.Info("Skipping invalid file in second instance args", "arg", arg, "err", err)
 			}
@@ -101,6 +167,9 @@ func (a *App) OnSecondInstanceLaunch(secondInstanceData options.SecondInstanceDa
 	if len(actualFiles) > 0 {
 		logger.Info("Adding files from second instance", "files", actualFiles)
 		a.pendingFiles = append(a.pendingFiles, actualFiles...)
+		for f, opts := range actualOptions {
+			a.launchOptions[f] = opts
+		}
 	} else if len(secondInstanceData.Args) > 0 {
 		logger.Info("No valid files found in second instance args.")
 	}
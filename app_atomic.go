@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// partPrefix starts the name of the hidden file a job writes into before
+// its output is complete.
+const partPrefix = ".c4s-"
+
+// partPath returns the temporary file for dest. The real extension is kept
+// after ".part" so that converters still detect the output format.
+func partPath(dest, id string) string {
+	return filepath.Join(filepath.Dir(dest), partPrefix+id+".part"+filepath.Ext(dest))
+}
+
+func isPartFile(path string) bool {
+	name := filepath.Base(path)
+	return strings.HasPrefix(name, partPrefix) && strings.Contains(name, ".part")
+}
+
+// newID returns a random identifier for jobs and their part files.
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// releaseDestination frees a path reserved by resolveDestination.
+func (a *App) releaseDestination(dest string) {
+	a.destMu.Lock()
+	defer a.destMu.Unlock()
+	delete(a.reserved, dest)
+}
+
+// makeOutputDir creates the folder of dest and its missing parents. The
+// returned function removes the folders it created again, for jobs that end
+// without an output; folders that still hold files, or where another job
+// reserved its output, are kept.
+func (a *App) makeOutputDir(dest string) (func(), error) {
+	a.destMu.Lock()
+	defer a.destMu.Unlock()
+
+	dir := filepath.Dir(dest)
+	var created []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil || filepath.Dir(d) == d {
+			break
+		}
+		created = append(created, d)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create output folder: %w", err)
+	}
+
+	return func() {
+		a.destMu.Lock()
+		defer a.destMu.Unlock()
+		for _, d := range created {
+			for path := range a.reserved {
+				if path != dest && strings.HasPrefix(path, d+string(filepath.Separator)) {
+					return
+				}
+			}
+			if os.Remove(d) != nil {
+				return
+			}
+		}
+	}, nil
+}
+
+// partJournal lists the part files of running jobs so that the ones left
+// behind by a crash can be removed at the next startup. With an empty path
+// it is kept in memory only.
+type partJournal struct {
+	mu    sync.Mutex
+	path  string
+	parts map[string]struct{}
+}
+
+func newPartJournal(path string) *partJournal {
+	return &partJournal{path: path, parts: make(map[string]struct{})}
+}
+
+// add records a part file before a converter starts writing it.
+func (j *partJournal) add(part string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.parts[part] = struct{}{}
+	j.save()
+}
+
+// done removes the part file, if it is still there, and forgets it.
+func (j *partJournal) done(part string) {
+	os.Remove(part)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	delete(j.parts, part)
+	j.save()
+}
+
+// save writes the journal. The caller must hold j.mu.
+func (j *partJournal) save() {
+	if j.path == "" {
+		return
+	}
+	list := make([]string, 0, len(j.parts))
+	for p := range j.parts {
+		list = append(list, p)
+	}
+	data, err := json.Marshal(list)
+	if err == nil {
+		err = os.WriteFile(j.path, data, 0644)
+	}
+	if err != nil {
+		logger.Warn("Could not save part file journal", "path", j.path, "error", err)
+	}
+}
+
+// cleanup removes the part files recorded by a previous run and returns how
+// many were deleted. Only files named like part files are touched.
+func (j *partJournal) cleanup() int {
+	if j.path == "" {
+		return 0
+	}
+	data, err := os.ReadFile(j.path)
+	if err != nil {
+		return 0
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return 0
+	}
+
+	removed := 0
+	for _, p := range list {
+		if isPartFile(p) && os.Remove(p) == nil {
+			removed++
+		}
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.save()
+	return removed
+}
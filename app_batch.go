@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// BatchSummary describes the jobs of one ConvertFiles call. InputBytes and
+// OutputBytes count the sources and outputs of the jobs that are done.
+// Elapsed is in seconds, up to now while the batch is running.
+type BatchSummary struct {
+	ID          string  `json:"id"`
+	Jobs        int     `json:"jobs"`
+	Done        int     `json:"done"`
+	Failed      int     `json:"failed"`
+	Skipped     int     `json:"skipped"`
+	Canceled    int     `json:"canceled"`
+	InputBytes  int64   `json:"inputBytes"`
+	OutputBytes int64   `json:"outputBytes"`
+	Elapsed     float64 `json:"elapsed"`
+	Finished    bool    `json:"finished"`
+}
+
+// batch tracks the jobs submitted together. outcomes holds the final status
+// of each job that ended: "done", "skipped", "error" or "canceled".
+type batch struct {
+	id          string
+	jobs        int
+	inputBytes  int64
+	outputBytes int64
+	started     time.Time
+	finished    time.Time
+	outcomes    map[string]string
+}
+
+// newBatch registers a batch of n jobs and returns its ID.
+func (a *App) newBatch(n int) string {
+	b := &batch{id: newID(), jobs: n, started: time.Now(), outcomes: make(map[string]string)}
+
+	a.mu.Lock()
+	a.batches[b.id] = b
+	a.mu.Unlock()
+	return b.id
+}
+
+// recordOutcome notes the final status of job in its batch. Only the first
+// outcome counts, so removing a finished job does not cancel it. The caller
+// must hold a.mu.
+func (a *App) recordOutcome(job *JobStatus, status string) {
+	switch status {
+	case "done", "skipped", "error", "canceled":
+	default:
+		return
+	}
+	b, ok := a.batches[job.Batch]
+	if !ok {
+		return
+	}
+	if _, ok := b.outcomes[job.ID]; !ok {
+		b.outcomes[job.ID] = status
+	}
+}
+
+// countBytes adds the sizes of a job's source and output to its batch.
+func (a *App) countBytes(id string, input, output int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if job, ok := a.jobs[id]; ok {
+		if b, ok := a.batches[job.Batch]; ok {
+			b.inputBytes += input
+			b.outputBytes += output
+		}
+	}
+}
+
+// finishBatch marks a batch as ended, counting the jobs that never reported
+// an outcome as canceled, and returns its summary.
+func (a *App) finishBatch(id string) BatchSummary {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	b, ok := a.batches[id]
+	if !ok {
+		return BatchSummary{ID: id, Finished: true}
+	}
+	b.finished = time.Now()
+	return b.summary()
+}
+
+// summary counts the outcomes of the batch. The caller must hold a.mu.
+func (b *batch) summary() BatchSummary {
+	s := BatchSummary{
+		ID:          b.id,
+		Jobs:        b.jobs,
+		InputBytes:  b.inputBytes,
+		OutputBytes: b.outputBytes,
+		Finished:    !b.finished.IsZero(),
+	}
+	for _, outcome := range b.outcomes {
+		switch outcome {
+		case "done":
+			s.Done++
+		case "skipped":
+			s.Skipped++
+		case "error":
+			s.Failed++
+		case "canceled":
+			s.Canceled++
+		}
+	}
+
+	end := time.Now()
+	if s.Finished {
+		end = b.finished
+		// Jobs stopped by a shutdown end without reporting.
+		s.Canceled = s.Jobs - s.Done - s.Skipped - s.Failed
+	}
+	s.Elapsed = end.Sub(b.started).Seconds()
+	return s
+}
+
+// GetBatch returns the summary of a batch, given the Batch of one of its
+// jobs. Batches are forgotten once all their jobs were cleared.
+func (a *App) GetBatch(id string) (BatchSummary, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	b, ok := a.batches[id]
+	if !ok {
+		return BatchSummary{}, fmt.Errorf("unknown batch %s", id)
+	}
+	return b.summary(), nil
+}
+
+// pruneBatches forgets finished batches that have no job left in the list.
+// The caller must hold a.mu.
+func (a *App) pruneBatches() {
+	listed := make(map[string]bool)
+	for _, job := range a.jobs {
+		listed[job.Batch] = true
+	}
+	for id, b := range a.batches {
+		if !b.finished.IsZero() && !listed[id] {
+			delete(a.batches, id)
+		}
+	}
+}
+
+// fileSize returns the size of the file at path, or 0 when it is missing.
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Content cache modes, set with contentCache.
+const (
+	contentCacheOff  = "off"
+	contentCacheFast = "fast"
+	contentCacheFull = "full"
+)
+
+// hashChunk is how much of each end of a file the fast hash reads.
+const hashChunk = 1 << 20
+
+// contentHash hashes the file at path. The "fast" mode reads only the size
+// and the first and last MiB, which tells apart the files people actually
+// re-submit without reading whole videos; "full" hashes every byte.
+func contentHash(path, mode string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	switch mode {
+	case contentCacheFull:
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+	case contentCacheFast:
+		info, err := f.Stat()
+		if err != nil {
+			return "", err
+		}
+		size := info.Size()
+		binary.Write(h, binary.LittleEndian, size)
+		if _, err := io.CopyN(h, f, min(size, hashChunk)); err != nil {
+			return "", err
+		}
+		if size > hashChunk {
+			tail := max(hashChunk, size-hashChunk)
+			if _, err := io.Copy(h, io.NewSectionReader(f, tail, size-tail)); err != nil {
+				return "", err
+			}
+		}
+	default:
+		return "", fmt.Errorf("unknown content cache mode %q", mode)
+	}
+	return mode + ":" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// validateContentCache checks the contentCache setting.
+func validateContentCache(mode string) error {
+	switch mode {
+	case "", contentCacheOff, contentCacheFast, contentCacheFull:
+		return nil
+	}
+	return fmt.Errorf("unknown content cache mode %q, expected off, fast or full", mode)
+}
+
+// cacheKey identifies a conversion by the content of its source, the
+// settings it is converted with and the folder, output template, date
+// folders and preset that name its output, so that an output is only reused
+// where a new conversion would have put it.
+func cacheKey(hash string, spec *jobSpec, destDir string) string {
+	tmpl := spec.template
+	if tmpl == "" {
+		tmpl = defaultOutputTemplate
+	}
+	return strings.Join([]string{hash, spec.fingerprint(), destDir, tmpl, spec.dateFolders, spec.preset}, "|")
+}
+
+// contentKey returns the cache key of src, or "" when the content cache is
+// off or the source cannot be read.
+func contentKey(src string, spec *jobSpec, destDir string) string {
+	mode := viper.GetString("contentCache")
+	if mode == "" || mode == contentCacheOff {
+		return ""
+	}
+	hash, err := contentHash(src, mode)
+	if err != nil {
+		logger.Warn("Could not hash source", "file", src, "error", err)
+		return ""
+	}
+	return cacheKey(hash, spec, destDir)
+}
+
+// cacheEntry records the output of a finished conversion.
+type cacheEntry struct {
+	Source        string    `json:"source"`
+	Output        string    `json:"output"`
+	OutputSize    int64     `json:"outputSize"`
+	OutputModTime time.Time `json:"outputModTime"`
+}
+
+// contentCache remembers finished conversions by cacheKey, so that a source
+// submitted again, even from another path, returns the existing output
+// instead of being converted again. With an empty path it is kept in memory
+// only.
+type contentCache struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]cacheEntry
+	// save is set while changes wait to be written.
+	save *time.Timer
+}
+
+func newContentCache(path string) *contentCache {
+	return &contentCache{path: path}
+}
+
+// load reads the cache file once, dropping entries whose output is gone.
+// The caller must hold c.mu.
+func (c *contentCache) load() {
+	if c.entries != nil {
+		return
+	}
+	c.entries = make(map[string]cacheEntry)
+	if c.path == "" {
+		return
+	}
+
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return
+	}
+	var entries map[string]cacheEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		logger.Warn("Ignoring unreadable content cache", "path", c.path, "error", err)
+		return
+	}
+	for key, e := range entries {
+		if _, err := os.Stat(e.Output); err == nil {
+			c.entries[key] = e
+		}
+	}
+}
+
+// lookup returns the output recorded for key if it still exists and has not
+// been modified since.
+func (c *contentCache) lookup(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	info, err := os.Stat(e.Output)
+	if err != nil || info.Size() != e.OutputSize || !info.ModTime().Equal(e.OutputModTime) {
+		delete(c.entries, key)
+		return "", false
+	}
+	return e.Output, true
+}
+
+// record stores a finished conversion. Like the manifest, the cache is
+// saved saveDelay later.
+func (c *contentCache) record(key, src, dest string) error {
+	info, err := os.Stat(dest)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+
+	c.entries[key] = cacheEntry{
+		Source:        src,
+		Output:        dest,
+		OutputSize:    info.Size(),
+		OutputModTime: info.ModTime(),
+	}
+	if c.path != "" && c.save == nil {
+		c.save = time.AfterFunc(saveDelay, c.flush)
+	}
+	return nil
+}
+
+// flush writes the changes that wait to be saved.
+func (c *contentCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.save == nil {
+		return
+	}
+	c.save.Stop()
+	c.save = nil
+	if err := writeJSONFile(c.path, c.entries); err != nil {
+		logger.Warn("Could not save content cache", "path", c.path, "error", err)
+	}
+}
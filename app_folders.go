@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/minjejeon/convert4share/windows"
+	"github.com/spf13/viper"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// folderScanTimeout is how long a scan waits for ConfirmFolderScan before
+// its files are dropped.
+const folderScanTimeout = 10 * time.Minute
+
+// Symlink policies of folder scans, set with folderSymlinks.
+const (
+	symlinksSkip   = "skip"
+	symlinksFollow = "follow"
+)
+
+// FolderScan describes the supported files found in folders submitted for
+// conversion. Confirm is set when the files wait for ConfirmFolderScan
+// before any job is queued.
+type FolderScan struct {
+	ID      string   `json:"id"`
+	Folders []string `json:"folders"`
+	Files   int      `json:"files"`
+	Bytes   int64    `json:"bytes"`
+	Confirm bool     `json:"confirm"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// scanOptions controls which files a folder scan picks up.
+type scanOptions struct {
+	// include and exclude hold compiled globs. A glob without "/" matches
+	// the name of a file or folder, otherwise the path relative to the
+	// scanned folder. Excluded folders are not entered.
+	include, exclude []folderGlob
+	// maxDepth is the number of folder levels scanned, 1 being the
+	// submitted folder alone; 0 means no limit.
+	maxDepth       int
+	skipHidden     bool
+	followSymlinks bool
+}
+
+// scannedFile is a file found by a folder scan. dir is its folder relative
+// to the scanned folder, "" for files directly inside it.
+type scannedFile struct {
+	path string
+	dir  string
+	size int64
+}
+
+// folderGlob is a compiled include or exclude glob. path is set when it
+// matches relative paths rather than names.
+type folderGlob struct {
+	re   *regexp.Regexp
+	path bool
+}
+
+// compileGlobs compiles the include or exclude globs of folder scans.
+func compileGlobs(globs []string) ([]folderGlob, error) {
+	var res []folderGlob
+	for _, g := range globs {
+		g = filepath.ToSlash(strings.TrimSpace(g))
+		if g == "" {
+			continue
+		}
+		re, err := regexp.Compile("(?i)^" + globToRegex(g) + "$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid folder glob %q: %w", g, err)
+		}
+		res = append(res, folderGlob{re: re, path: strings.Contains(g, "/")})
+	}
+	return res, nil
+}
+
+// folderScanOptions reads the scan options from the settings.
+func folderScanOptions() (scanOptions, error) {
+	opts := scanOptions{
+		maxDepth:       viper.GetInt("folderMaxDepth"),
+		skipHidden:     viper.GetBool("folderSkipHidden"),
+		followSymlinks: viper.GetString("folderSymlinks") == symlinksFollow,
+	}
+	var err error
+	if opts.include, err = compileGlobs(viper.GetStringSlice("folderInclude")); err != nil {
+		return opts, err
+	}
+	if opts.exclude, err = compileGlobs(viper.GetStringSlice("folderExclude")); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// validateFolderSettings checks the folder scan settings.
+func validateFolderSettings(s Settings) error {
+	if _, err := compileGlobs(s.FolderInclude); err != nil {
+		return err
+	}
+	if _, err := compileGlobs(s.FolderExclude); err != nil {
+		return err
+	}
+	if s.FolderMaxDepth < 0 {
+		return fmt.Errorf("folder depth must not be negative")
+	}
+	switch s.FolderSymlinks {
+	case "", symlinksSkip, symlinksFollow:
+	default:
+		return fmt.Errorf("unknown symlink policy %q, expected skip or follow", s.FolderSymlinks)
+	}
+	return nil
+}
+
+// matchGlobs reports whether one of globs matches the entry with the given
+// name and path relative to the scanned folder.
+func matchGlobs(globs []folderGlob, rel, name string) bool {
+	for _, g := range globs {
+		if g.path && g.re.MatchString(rel) || !g.path && g.re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func isHiddenFile(path, name string) bool {
+	return strings.HasPrefix(name, ".") || windows.IsHidden(path)
+}
+
+// scan lists the supported files below root in name order. Unreadable
+// subfolders are skipped; only an unreadable root is an error. Followed
+// symlinks that lead back to a folder already scanned are ignored.
+func (o scanOptions) scan(root string) ([]scannedFile, error) {
+	var files []scannedFile
+	visited := make(map[string]bool)
+
+	var walk func(dir, rel string, depth int) error
+	walk = func(dir, rel string, depth int) error {
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			if visited[real] {
+				return nil
+			}
+			visited[real] = true
+		}
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			name := e.Name()
+			path := filepath.Join(dir, name)
+			relPath := name
+			if rel != "" {
+				relPath = rel + "/" + name
+			}
+			if o.skipHidden && isHiddenFile(path, name) {
+				continue
+			}
+			if matchGlobs(o.exclude, relPath, name) {
+				continue
+			}
+
+			var info fs.FileInfo
+			if e.Type()&fs.ModeSymlink != 0 {
+				if !o.followSymlinks {
+					continue
+				}
+				info, err = os.Stat(path)
+			} else {
+				info, err = e.Info()
+			}
+			if err != nil {
+				continue
+			}
+
+			if info.IsDir() {
+				if o.maxDepth > 0 && depth >= o.maxDepth {
+					continue
+				}
+				if err := walk(path, relPath, depth+1); err != nil {
+					logger.Warn("Skipping unreadable folder", "folder", path, "error", err)
+				}
+				continue
+			}
+			if !info.Mode().IsRegular() || info.Size() == 0 || sourcePool(filepath.Ext(name)) == "" {
+				continue
+			}
+			if len(o.include) > 0 && !matchGlobs(o.include, relPath, name) {
+				continue
+			}
+			files = append(files, scannedFile{path: path, dir: filepath.FromSlash(rel), size: info.Size()})
+		}
+		return nil
+	}
+
+	return files, walk(root, "", 1)
+}
+
+// splitFolders separates requests for folders from requests for files.
+func splitFolders(requests []ConvertRequest) (files, folders []ConvertRequest) {
+	for _, req := range requests {
+		if info, err := os.Stat(strings.Trim(req.File, "\"")); err == nil && info.IsDir() {
+			folders = append(folders, req)
+		} else {
+			files = append(files, req)
+		}
+	}
+	return files, folders
+}
+
+// expandFolders turns folder requests into requests for the files found
+// below them, each with the options of its folder. With mirrorFolders, a
+// file keeps its place below the folder in the destination.
+func expandFolders(folders []ConvertRequest) (FolderScan, []ConvertRequest) {
+	scan := FolderScan{ID: newID()}
+	opts, err := folderScanOptions()
+	if err != nil {
+		scan.Error = err.Error()
+		return scan, nil
+	}
+
+	var requests []ConvertRequest
+	var failed []string
+	for _, folder := range folders {
+		root := strings.Trim(folder.File, "\"")
+		if abs, err := filepath.Abs(root); err == nil {
+			root = abs
+		}
+		scan.Folders = append(scan.Folders, root)
+
+		files, err := opts.scan(root)
+		if err != nil {
+			failed = append(failed, err.Error())
+			continue
+		}
+		for _, f := range files {
+			req := ConvertRequest{File: f.path, Options: folder.Options}
+			if viper.GetBool("mirrorFolders") {
+				req.Subdir = filepath.Join(filepath.Base(root), f.dir)
+			}
+			requests = append(requests, req)
+			scan.Bytes += f.size
+		}
+	}
+	scan.Files = len(requests)
+	scan.Error = strings.Join(failed, "; ")
+	return scan, requests
+}
+
+// scanFolders expands folder requests and reports the result with a
+// "folder-scanned" event. Scans finding more than folderConfirmCount files
+// wait for ConfirmFolderScan, up to folderScanTimeout; smaller ones are
+// converted right away.
+func (a *App) scanFolders(folders []ConvertRequest) {
+	scan, requests := expandFolders(folders)
+	limit := viper.GetInt("folderConfirmCount")
+	scan.Confirm = limit > 0 && scan.Files > limit
+	if scan.Confirm {
+		a.mu.Lock()
+		if !a.closing {
+			a.folderScans[scan.ID] = requests
+		}
+		a.mu.Unlock()
+		time.AfterFunc(folderScanTimeout, func() { a.expireFolderScan(scan.ID) })
+	}
+	logger.Info("Scanned folders", "folders", scan.Folders, "files", scan.Files, "confirm", scan.Confirm)
+	runtime.EventsEmit(a.ctx, "folder-scanned", scan)
+
+	if !scan.Confirm && len(requests) > 0 {
+		a.ConvertFilesWithOptions(requests)
+	}
+}
+
+// ConfirmFolderScan converts the files of a scan that waits for
+// confirmation, or drops them when accept is false.
+func (a *App) ConfirmFolderScan(id string, accept bool) error {
+	a.mu.Lock()
+	requests, ok := a.folderScans[id]
+	delete(a.folderScans, id)
+	a.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown folder scan %s", id)
+	}
+
+	if accept {
+		a.ConvertFilesWithOptions(requests)
+	}
+	return nil
+}
+
+// expireFolderScan drops a scan that was not answered in time and tells the
+// UI with a "folder-scan-expired" event.
+func (a *App) expireFolderScan(id string) {
+	a.mu.Lock()
+	_, ok := a.folderScans[id]
+	delete(a.folderScans, id)
+	a.mu.Unlock()
+	if ok && a.ctx != nil {
+		runtime.EventsEmit(a.ctx, "folder-scan-expired", id)
+	}
+}
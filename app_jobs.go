@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/minjejeon/convert4share/converter"
 	"github.com/spf13/viper"
@@ -17,10 +19,129 @@ type JobStatus struct {
 	ID       string `json:"id"`
 	File     string `json:"file"`
 	DestFile string `json:"destFile,omitempty"`
-	Status   string `json:"status"` // "queued", "processing", "done", "error"
+	Status   string `json:"status"` // "queued", "processing", "suspended", "done", "skipped", "error"
 	Progress int    `json:"progress"`
 	Speed    string `json:"speed,omitempty"`
-	Error    string `json:"error,omitempty"`
+	// ETA is the estimated number of seconds left, 0 while unknown.
+	ETA   int    `json:"eta,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Batch is the ID of the ConvertFiles call that queued the job.
+	Batch string `json:"batch"`
+
+	// request is what the job was queued with, for saving the queue.
+	request ConvertRequest
+	// started is set once the scheduler let the job run, before it reports
+	// "processing".
+	started bool
+}
+
+// newJob registers a queued job of a batch for req under a new ID and
+// announces it to the frontend with a "job-queued" event.
+func (a *App) newJob(req ConvertRequest, batch string) string {
+	job := &JobStatus{ID: newID(), File: strings.Trim(req.File, "\""), Status: "queued", Batch: batch, request: req}
+
+	a.mu.Lock()
+	a.jobs[job.ID] = job
+	a.jobOrder = append(a.jobOrder, job.ID)
+	a.mu.Unlock()
+
+	runtime.EventsEmit(a.ctx, "job-queued", *job)
+	return job.ID
+}
+
+// updateJob changes a job and emits a "conversion-progress" event. Jobs
+// removed by CancelJob are not brought back.
+func (a *App) updateJob(id string, update func(job *JobStatus)) {
+	a.mu.Lock()
+	job, ok := a.jobs[id]
+	if !ok {
+		a.mu.Unlock()
+		return
+	}
+	update(job)
+	snapshot := *job
+	a.mu.Unlock()
+
+	// There is no frontend to notify before startup.
+	if a.ctx != nil {
+		runtime.EventsEmit(a.ctx, "conversion-progress", snapshot)
+	}
+}
+
+// reportJob updates the status of a job.
+func (a *App) reportJob(id string, destFile string, percent int, status string, errMsg string, speed string) {
+	a.updateJob(id, func(job *JobStatus) {
+		job.DestFile = destFile
+		job.Status = status
+		job.Progress = percent
+		job.Error = errMsg
+		job.Speed = speed
+		job.ETA = 0
+		a.recordOutcome(job, status)
+	})
+}
+
+// reportProgress updates a running job. A suspended job keeps its status,
+// as progress lines may arrive after it was suspended.
+func (a *App) reportProgress(id string, destFile string, percent int, speed string, eta time.Duration) {
+	a.updateJob(id, func(job *JobStatus) {
+		if job.Status != "suspended" {
+			job.Status = "processing"
+		}
+		job.DestFile = destFile
+		job.Progress = percent
+		job.Speed = speed
+		job.ETA = int(eta.Seconds())
+	})
+}
+
+// GetJobs returns a snapshot of all jobs in the order they were queued,
+// including finished ones until they are cleared. Jobs waiting in the
+// scheduler appear in the order they will start.
+func (a *App) GetJobs() []JobStatus {
+	waiting := a.sched.order()
+	isWaiting := make(map[string]bool, len(waiting))
+	for _, id := range waiting {
+		isWaiting[id] = true
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	jobs := make([]JobStatus, 0, len(a.jobOrder))
+	next := 0
+	for _, id := range a.jobOrder {
+		// The waiting jobs keep their places in the list but are listed
+		// in dispatch order.
+		if isWaiting[id] {
+			id = waiting[next]
+			next++
+		}
+		if job, ok := a.jobs[id]; ok {
+			jobs = append(jobs, *job)
+		}
+	}
+	return jobs
+}
+
+// ClearCompletedJobs forgets jobs that finished successfully or were skipped.
+func (a *App) ClearCompletedJobs() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	order := a.jobOrder[:0]
+	for _, id := range a.jobOrder {
+		job, ok := a.jobs[id]
+		if ok && (job.Status == "done" || job.Status == "skipped") {
+			delete(a.jobs, id)
+			continue
+		}
+		if ok {
+			order = append(order, id)
+		}
+	}
+	a.jobOrder = order
+	a.pruneBatches()
 }
 
 func (a *App) processPendingFiles() {
@@ -42,7 +163,7 @@ func (a *App) processPendingFiles() {
 	go func(files []string) {
 		var validFiles []string
 		for _, f := range files {
-			if info, err := os.Stat(f); err == nil && !info.IsDir() {
+			if _, err := os.Stat(f); err == nil {
 				if absArg, err := filepath.Abs(f); err == nil {
 					validFiles = append(validFiles, absArg)
 				} else {
@@ -60,6 +181,7 @@ func (a *App) processPendingFiles() {
 	}(filesToProcess)
 }
 
+// CancelJob stops a queued or running job and removes it from the job list.
 func (a *App) CancelJob(id string) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
@@ -67,58 +189,135 @@ func (a *App) CancelJob(id string) {
 		cancel()
 		delete(a.jobCancels, id)
 	}
-	a.pauseCond.Broadcast()
+	if job, ok := a.jobs[id]; ok {
+		a.recordOutcome(job, "canceled")
+	}
+	delete(a.jobs, id)
 }
 
-func (a *App) PauseQueue() {
+// SuspendJob suspends the converter process of a running job until
+// ResumeJob. The job keeps its worker slot while suspended.
+func (a *App) SuspendJob(id string) error {
 	a.mu.Lock()
-	defer a.mu.Unlock()
-	a.isPaused = true
+	ctl, ok := a.jobControls[id]
+	a.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("job %s is not running", id)
+	}
+
+	if err := ctl.Suspend(); err != nil {
+		return fmt.Errorf("could not suspend job: %w", err)
+	}
+	a.updateJob(id, func(job *JobStatus) {
+		job.Status = "suspended"
+		job.Speed = ""
+		job.ETA = 0
+	})
+	return nil
+}
+
+// ResumeJob continues a job suspended by SuspendJob.
+func (a *App) ResumeJob(id string) error {
+	a.mu.Lock()
+	ctl, ok := a.jobControls[id]
+	a.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("job %s is not running", id)
+	}
+
+	if err := ctl.Resume(); err != nil {
+		return fmt.Errorf("could not resume job: %w", err)
+	}
+	a.updateJob(id, func(job *JobStatus) {
+		job.Status = "processing"
+	})
+	return nil
+}
+
+// PauseQueue stops jobs from starting; running jobs continue.
+func (a *App) PauseQueue() {
+	a.sched.setPaused(true)
 	runtime.EventsEmit(a.ctx, "queue-paused", true)
 }
 
 func (a *App) ResumeQueue() {
-	a.mu.Lock()
-	defer a.mu.Unlock()
-	a.isPaused = false
-	a.pauseCond.Broadcast()
+	a.sched.setPaused(false)
 	runtime.EventsEmit(a.ctx, "queue-resumed", true)
 }
 
-func (a *App) ConvertFiles(files []string) {
+// ConvertFiles converts files with the global settings, applying any options
+// that were given for them on the command line. It returns the job IDs.
+func (a *App) ConvertFiles(files []string) []string {
+	requests := make([]ConvertRequest, 0, len(files))
+
+	a.mu.Lock()
+	for _, f := range files {
+		requests = append(requests, ConvertRequest{File: f, Options: a.launchOptions[f]})
+		delete(a.launchOptions, f)
+	}
+	a.mu.Unlock()
+
+	return a.ConvertFilesWithOptions(requests)
+}
+
+// ConvertFilesWithOptions converts each file with its own overrides on top of
+// the global settings. Every request becomes its own job, so the same file
+// can be converted several times with different options. It returns the job
+// IDs in the order of the requests.
+//
+// Folders are scanned in the background and their files converted as a
+// batch of their own, see scanFolders; their jobs are not returned.
+//
+// The jobs form a batch, whose ID is the Batch of each job. When all of them
+// have ended, a "batch-finished" event carries the BatchSummary.
+func (a *App) ConvertFilesWithOptions(requests []ConvertRequest) []string {
+	requests, folders := splitFolders(requests)
+	if len(folders) > 0 {
+		go a.scanFolders(folders)
+	}
+	if len(requests) == 0 {
+		return nil
+	}
+
+	a.mu.Lock()
+	closing := a.closing
+	if !closing {
+		a.jobsWG.Add(1)
+	}
+	a.mu.Unlock()
+	if closing {
+		logger.Info("Ignoring files while closing", "count", len(requests))
+		return nil
+	}
+
+	batchID := a.newBatch(len(requests))
+	ids := make([]string, len(requests))
+	for i, req := range requests {
+		ids[i] = a.newJob(req, batchID)
+	}
+
 	go func() {
+		defer a.jobsWG.Done()
 		var wg sync.WaitGroup
-		convConfig := &converter.Config{
-			MagickBinary:        viper.GetString("magickBinary"),
-			FfmpegBinary:        viper.GetString("ffmpegBinary"),
-			MaxSize:             viper.GetInt("maxSize"),
-			HardwareAccelerator: viper.GetString("hardwareAccelerator"),
-			FfmpegCustomArgs:    viper.GetString("ffmpegCustomArgs"),
-			VideoQuality:        viper.GetString("videoQuality"),
-		}
-		collisionOption := viper.GetString("collisionOption")
-
-		reporter := func(file string, destFile string, percent int, status string, errMsg string, speed string) {
-			runtime.EventsEmit(a.ctx, "conversion-progress", JobStatus{
-				ID:       file,
-				File:     file,
-				DestFile: destFile,
-				Status:   status,
-				Progress: percent,
-				Error:    errMsg,
-				Speed:    speed,
-			})
-		}
 
-		a.mu.Lock()
-		ffmpegSem := a.ffmpegSem
-		magickSem := a.magickSem
-		a.mu.Unlock()
+		reporter := a.reportJob
+		rules := compileRoutes(routeRules())
+
+		// Every job of the batch is prepared before any is queued, so that
+		// the batch is dispatched in priority order.
+		type preparedJob struct {
+			queued  *queuedJob
+			src     string
+			ext     string
+			destDir string
+			spec    *jobSpec
+		}
+		var prepared []preparedJob
 
-		for _, f := range files {
+		for i, req := range requests {
 			// Trim surrounding quotes if present
-			cleanPath := strings.Trim(f, "\"")
-			jobID := cleanPath
+			cleanPath := strings.Trim(req.File, "\"")
+			jobID := ids[i]
 			sysPath := cleanPath
 			if abs, err := filepath.Abs(sysPath); err == nil {
 				sysPath = abs
@@ -142,53 +341,70 @@ func (a *App) ConvertFiles(files []string) {
 				continue
 			}
 
-			a.mu.Lock()
-			if _, ok := a.jobCancels[jobID]; ok {
-				a.mu.Unlock()
-				logger.Warn("Skipping file as it is already being processed", "file", jobID)
+			ext := strings.ToLower(filepath.Ext(sysPath))
+			pool := sourcePool(ext)
+			if pool == "" {
+				reporter(jobID, "", 0, "error", "Unsupported format", "")
 				continue
 			}
-			a.mu.Unlock()
 
-			ext := strings.ToLower(filepath.Ext(sysPath))
+			// An explicit preset or output folder wins over the routing rule.
+			routed := route(rules, sysPath, info.Size())
+			opts := req.Options
+			if opts.Preset == "" {
+				opts.Preset = routed.Preset
+			}
+			if opts.SourceAction == "" {
+				opts.SourceAction = routed.SourceAction
+			}
+			if opts.ArchiveDir == "" {
+				opts.ArchiveDir = routed.ArchiveDir
+			}
 
-			fname := filepath.Base(sysPath)
-			stem := strings.TrimSuffix(fname, filepath.Ext(fname))
-			parent := filepath.Dir(sysPath)
-			cleanedParent := filepath.Clean(parent)
-
-			destDir := parent
-			for _, pat := range viper.GetStringSlice("excludeStringPatterns") {
-				cleanedPat := filepath.Clean(pat)
-				if strings.Contains(cleanedParent, cleanedPat) {
-					destDir = os.ExpandEnv(viper.GetString("defaultDestDir"))
-					break
-				}
+			spec, err := a.resolveJobSpec(opts)
+			if err != nil {
+				reporter(jobID, "", 0, "error", err.Error(), "")
+				continue
+			}
+
+			destDir := routed.DestDir
+			if spec.outputDir != "" {
+				destDir = spec.outputDir
+			}
+			// Files found in a folder keep their place below it, unless
+			// the output goes next to the source anyway.
+			if req.Subdir != "" && destDir != filepath.Dir(sysPath) {
+				destDir = filepath.Join(destDir, req.Subdir)
 			}
 
+			// Jobs are queued with weight 1 and weighed once they start.
+			class := jobPriority(sysPath, info.Size())
+			prepared = append(prepared, preparedJob{
+				queued:  newQueuedJob(jobID, pool, class, 1),
+				src:     sysPath,
+				ext:     ext,
+				destDir: destDir,
+				spec:    spec,
+			})
+		}
+
+		queued := make([]*queuedJob, len(prepared))
+		for i, p := range prepared {
+			queued[i] = p.queued
+		}
+		a.sched.add(queued...)
+
+		for _, p := range prepared {
 			wg.Add(1)
-			go func(id string, src string, extension string) {
+			go func(job *queuedJob, src, extension, destDir string, spec *jobSpec) {
 				defer wg.Done()
+				id := job.id
+				defer a.sched.finish(job)
 
 				a.mu.Lock()
 				jobCtx, cancel := context.WithCancel(a.ctx)
 				a.jobCancels[id] = cancel
-
-				for a.isPaused {
-					if jobCtx.Err() != nil {
-						delete(a.jobCancels, id)
-						a.mu.Unlock()
-						cancel()
-						return
-					}
-					a.pauseCond.Wait()
-				}
-				if jobCtx.Err() != nil {
-					delete(a.jobCancels, id)
-					a.mu.Unlock()
-					cancel()
-					return
-				}
+				_, listed := a.jobs[id]
 				a.mu.Unlock()
 
 				defer func() {
@@ -197,120 +413,292 @@ func (a *App) ConvertFiles(files []string) {
 					delete(a.jobCancels, id)
 					a.mu.Unlock()
 				}()
+				// CancelJob may have run before the cancel function existed.
+				if !listed {
+					return
+				}
 
-				var err error
-				var dest string
-
+				outExt := ".jpg"
 				if extension == ".mov" {
-					dest, err = a.resolveDestination(destDir, stem, ".mp4", collisionOption)
-					if err != nil {
-						reporter(id, "", 100, "error", err.Error(), "")
-						return
-					}
+					outExt = ".mp4"
+				}
 
-					reporter(id, dest, 0, "pending", "", "")
+				reporter(id, "", 0, "pending", "", "")
 
+				// Hashing the source and probing it for the output name run
+				// once the job holds its share of the worker pool rather than
+				// for every queued job at once.
+				select {
+				case <-job.start:
+				case <-jobCtx.Done():
+					return
+				}
+				a.markStarted(id)
+				// A job heavier or lighter than assumed waits again, at the
+				// front of the queue, until its pool has room for it.
+				if weight := jobWeight(jobCtx, spec.conv, src); weight != job.weight {
+					a.sched.requeue(job, weight)
 					select {
-					case ffmpegSem <- struct{}{}:
-						defer func() { <-ffmpegSem }()
+					case <-job.start:
 					case <-jobCtx.Done():
 						return
 					}
+				}
 
-					reporter(id, dest, 0, "processing", "", "")
-
-					err = convConfig.Ffmpeg(jobCtx, src, dest, func(progress int, speed string) {
-						reporter(id, dest, progress, "processing", "", speed)
-					})
-				} else if extension == ".heic" {
-					dest, err = a.resolveDestination(destDir, stem, ".jpg", collisionOption)
-					if err != nil {
-						reporter(id, "", 100, "error", err.Error(), "")
+				// Stat the source before converting, as the manifest must
+				// describe the file that was actually read.
+				source := statSource(src, spec)
+
+				// A source converted before with the same settings, even
+				// under another path, returns the existing output.
+				key := contentKey(src, spec, destDir)
+				if key != "" {
+					if out, ok := a.cache.lookup(key); ok {
+						logger.Info("Reusing converted output", "file", src, "output", out)
+						a.countBytes(id, source.Size, fileSize(out))
+						reporter(id, out, 100, "done", "", "")
 						return
 					}
+				}
 
-					reporter(id, dest, 0, "pending", "", "")
+				dest, err := a.resolveOutput(jobCtx, destDir, source, outExt, spec)
+				if reportSkipped(reporter, id, err) {
+					return
+				}
+				if err != nil {
+					reporter(id, "", 100, "error", err.Error(), "")
+					return
+				}
+				defer a.releaseDestination(dest)
+				part := partPath(dest, id)
 
-					select {
-					case magickSem <- struct{}{}:
-						defer func() { <-magickSem }()
-					case <-jobCtx.Done():
-						return
+				// Folders of the output are only created now, and removed
+				// again after the part file when the job ends without an
+				// output.
+				removeDirs, err := a.makeOutputDir(dest)
+				if err != nil {
+					reporter(id, "", 100, "error", err.Error(), "")
+					return
+				}
+				converted := false
+				defer func() {
+					if !converted {
+						removeDirs()
 					}
+				}()
+				a.parts.add(part)
+				defer a.parts.done(part)
+
+				ctl := &converter.Control{}
+				a.mu.Lock()
+				a.jobControls[id] = ctl
+				a.mu.Unlock()
+				defer func() {
+					a.mu.Lock()
+					delete(a.jobControls, id)
+					a.mu.Unlock()
+				}()
+				convCtx := converter.WithControl(jobCtx, ctl)
 
+				reporter(id, dest, 0, "processing", "", "")
+
+				for attempt := 1; ; attempt++ {
+					if extension == ".mov" {
+						err = spec.conv.Ffmpeg(convCtx, src, part, func(progress int, speed string, eta time.Duration) {
+							a.reportProgress(id, dest, progress, speed, eta)
+						})
+					} else {
+						err = spec.conv.Magick(convCtx, src, part)
+					}
+					if !shouldRetry(err, attempt) || jobCtx.Err() != nil {
+						break
+					}
+					logger.Warn("Retrying job", "file", src, "attempt", attempt, "error", err)
 					reporter(id, dest, 0, "processing", "", "")
+				}
 
-					err = convConfig.Magick(jobCtx, src, dest)
-				} else {
-					reporter(id, "", 0, "error", "Unsupported format", "")
-					return
+				// A zero exit status does not guarantee a usable file.
+				if err == nil {
+					err = spec.conv.Verify(jobCtx, src, part)
+				}
+				// Only a verified output replaces dest; the part file is
+				// removed in all other cases.
+				if err == nil {
+					err = os.Rename(part, dest)
 				}
 
 				if err != nil {
-					if dest != "" {
-						os.Remove(dest)
-					}
 					reporter(id, dest, 100, "error", err.Error(), "")
 				} else {
+					converted = true
+					if err := preserveTimestamps(jobCtx, spec, src, dest); err != nil {
+						logger.Warn("Could not preserve timestamps", "file", dest, "error", err)
+					}
+					if err := a.manifest.record(dest, source); err != nil {
+						logger.Warn("Could not update manifest", "file", dest, "error", err)
+					}
+					if key != "" {
+						if err := a.cache.record(key, src, dest); err != nil {
+							logger.Warn("Could not update content cache", "file", dest, "error", err)
+						}
+					}
+					if err := applySourceAction(spec, src, verifyOutput(src, dest)); err != nil {
+						logger.Warn("Source action failed", "file", src, "action", spec.sourceAction, "error", err)
+					}
+					a.countBytes(id, source.Size, fileSize(dest))
 					reporter(id, dest, 100, "done", "", "")
 				}
-			}(jobID, sysPath, ext)
+			}(p.queued, p.src, p.ext, p.destDir, p.spec)
 		}
 
 		wg.Wait()
+		runtime.EventsEmit(a.ctx, "batch-finished", a.finishBatch(batchID))
 		runtime.EventsEmit(a.ctx, "all-jobs-done", true)
 	}()
+
+	return ids
+}
+
+// markStarted notes that the scheduler let a job run, so that closing the
+// window waits for it even before it starts converting.
+func (a *App) markStarted(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if job, ok := a.jobs[id]; ok {
+		job.started = true
+	}
+}
+
+// shouldRetry reports whether a conversion that failed with err on the given
+// attempt is tried again. Only stalled and timed out conversions are
+// retried, up to maxRetries times, as other errors would fail the same way.
+func shouldRetry(err error, attempt int) bool {
+	var timeout *converter.TimeoutError
+	return errors.As(err, &timeout) && attempt <= viper.GetInt("maxRetries")
+}
+
+// skippedError reports that a job was not converted because its output
+// already exists, as requested by the collision option.
+type skippedError struct {
+	dest   string
+	reason string
+}
+
+func (e *skippedError) Error() string {
+	return fmt.Sprintf("skipped: %s (%s)", e.reason, e.dest)
+}
+
+// validateCollision checks a collision option, so that a typo in a preset
+// or launch option is reported instead of acting like "rename".
+func validateCollision(option string) error {
+	switch option {
+	case "", "rename", "overwrite", "error", "skip", "skip-if-identical", "replace-if-older":
+		return nil
+	}
+	return fmt.Errorf("unknown collision option %q, expected rename, overwrite, error, skip, skip-if-identical or replace-if-older", option)
 }
 
-func (a *App) resolveDestination(dir, name, ext, collisionOption string) (string, error) {
+// resolveDestination picks the output path for name+ext in dir according to
+// the collision option: "rename" (default), "overwrite", "error", "skip",
+// "skip-if-identical", which skips outputs the manifest shows were converted
+// from the same unchanged source with the same settings and renames
+// otherwise, and "replace-if-older", which overwrites outputs older than the
+// source and skips the others. Skipped jobs return a *skippedError.
+//
+// The returned path is reserved in memory until releaseDestination, so that
+// concurrent jobs never pick the same name; a path is taken when it exists
+// on disk or is reserved.
+func (a *App) resolveDestination(dir, name, ext, collisionOption string, src sourceFile) (string, error) {
 	a.destMu.Lock()
 	defer a.destMu.Unlock()
 
 	dest := filepath.Join(dir, name+ext)
 
-	createPlaceholder := func(path string) bool {
-		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL, 0666)
-		if err == nil {
-			f.Close()
-			return true
-		}
-		return false
+	reserve := func(path string) (string, error) {
+		a.reserved[path] = struct{}{}
+		return path, nil
 	}
 
 	if collisionOption == "overwrite" {
-		return dest, nil
+		return reserve(dest)
 	}
 
+	_, reserved := a.reserved[dest]
 	info, err := os.Stat(dest)
-	if os.IsNotExist(err) {
-		if createPlaceholder(dest) {
-			return dest, nil
-		}
-		// Refresh info if creation failed (race lost)
-		info, err = os.Stat(dest)
-	}
-
-	// If file exists and is 0 bytes, overwrite it
-	if err == nil && info != nil && info.Size() == 0 {
-		return dest, nil
+	if !reserved && os.IsNotExist(err) {
+		return reserve(dest)
 	}
 
-	if collisionOption == "error" {
+	switch collisionOption {
+	case "error":
 		return "", fmt.Errorf("file already exists: %s", dest)
+	case "skip":
+		return dest, &skippedError{dest: dest, reason: "output already exists"}
+	case "skip-if-identical":
+		if !reserved && a.manifest.identical(dest, src) {
+			return dest, &skippedError{dest: dest, reason: "already converted"}
+		}
+	case "replace-if-older":
+		// A reserved name belongs to another job of this run and is renamed.
+		if !reserved && err == nil {
+			if src.ModTime.After(info.ModTime()) {
+				return reserve(dest)
+			}
+			return dest, &skippedError{dest: dest, reason: "output is up to date"}
+		}
 	}
 
 	for i := 1; ; i++ {
 		d := filepath.Join(dir, fmt.Sprintf("%s (%d)%s", name, i, ext))
-		if createPlaceholder(d) {
-			return d, nil
+		if _, ok := a.reserved[d]; ok {
+			continue
 		}
+		if _, err := os.Lstat(d); os.IsNotExist(err) {
+			return reserve(d)
+		}
+	}
+}
+
+// reportSkipped reports a job skipped by the collision option and returns
+// whether err was such a skip.
+func reportSkipped(reporter func(string, string, int, string, string, string), id string, err error) bool {
+	var skipped *skippedError
+	if !errors.As(err, &skipped) {
+		return false
 	}
+	reporter(id, skipped.dest, 100, "skipped", "", "")
+	return true
+}
+
+// preserveTimestamps sets the modification and access times of dest
+// according to the preserveTimestamps setting: "source" copies the source's
+// modification time, "capture" uses the capture date, which falls back to a
+// date in the file name and then to the source's modification time.
+func preserveTimestamps(ctx context.Context, spec *jobSpec, src, dest string) error {
+	var t time.Time
+	switch spec.timestamps {
+	case "source":
+		info, err := os.Stat(src)
+		if err != nil {
+			return err
+		}
+		t = info.ModTime()
+	case "capture":
+		var err error
+		if t, _, err = spec.conv.CaptureDate(ctx, src); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+
+	return os.Chtimes(dest, t, t)
 }
 
 func (a *App) AddFiles(files []string) {
 	logger.Info("AddFiles called", "files", files)
 	for _, f := range files {
-		if info, err := os.Stat(f); err == nil && !info.IsDir() && info.Size() > 0 {
+		if info, err := os.Stat(f); err == nil && (info.IsDir() || info.Size() > 0) {
 			if absArg, err := filepath.Abs(f); err == nil {
 				runtime.EventsEmit(a.ctx, "file-added", absArg)
 			} else {
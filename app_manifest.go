@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// sourceFile identifies the input of a conversion and the settings it is
+// converted with, so that a later run can tell whether it would produce the
+// same output.
+type sourceFile struct {
+	Path     string
+	Size     int64
+	ModTime  time.Time
+	Settings string
+}
+
+// statSource describes src for the manifest. A missing file yields an empty
+// description, which never matches a manifest entry.
+func statSource(src string, spec *jobSpec) sourceFile {
+	s := sourceFile{Path: src, Settings: spec.fingerprint()}
+	if info, err := os.Stat(src); err == nil {
+		s.Size = info.Size()
+		s.ModTime = info.ModTime()
+	}
+	return s
+}
+
+// fingerprint summarizes the conversion settings that affect the output.
+// Settings that only change how the converters run, such as binaries,
+// priority, resource limits and timeouts, are left out, so changing them
+// keeps earlier outputs valid.
+func (s *jobSpec) fingerprint() string {
+	if s.conv == nil {
+		return ""
+	}
+	conv := *s.conv
+	conv.MagickBinary, conv.FfmpegBinary = "", ""
+	conv.Priority = ""
+	conv.FfmpegThreads, conv.MagickThreads = 0, 0
+	conv.MagickMemoryLimit, conv.MagickDiskLimit = "", ""
+	conv.StallTimeout, conv.TimeoutFactor = 0, 0
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%+v", conv)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// manifestEntry records a finished conversion.
+type manifestEntry struct {
+	Source        string    `json:"source"`
+	SourceSize    int64     `json:"sourceSize"`
+	SourceModTime time.Time `json:"sourceModTime"`
+	Settings      string    `json:"settings"`
+	OutputSize    int64     `json:"outputSize"`
+	OutputModTime time.Time `json:"outputModTime"`
+}
+
+// saveDelay is how long the manifest and the content cache wait before
+// writing a change, so that a batch of jobs writes them once rather than
+// after every job.
+const saveDelay = 2 * time.Second
+
+// writeJSONFile replaces the file at path with v as indented JSON.
+func writeJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// manifest remembers previous conversions keyed by output path, for the
+// skip-if-identical collision option. With an empty path it is kept in
+// memory only.
+type manifest struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]manifestEntry
+	// save is set while changes wait to be written.
+	save *time.Timer
+}
+
+func newManifest(path string) *manifest {
+	return &manifest{path: path}
+}
+
+// load reads the manifest file once, dropping entries whose output is gone.
+// The caller must hold m.mu.
+func (m *manifest) load() {
+	if m.entries != nil {
+		return
+	}
+	m.entries = make(map[string]manifestEntry)
+	if m.path == "" {
+		return
+	}
+
+	data, err := os.ReadFile(m.path)
+	if err != nil {
+		return
+	}
+	var entries map[string]manifestEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		logger.Warn("Ignoring unreadable manifest", "path", m.path, "error", err)
+		return
+	}
+	for dest, e := range entries {
+		if _, err := os.Stat(dest); err == nil {
+			m.entries[dest] = e
+		}
+	}
+}
+
+// identical reports whether dest was produced from src, unchanged, with the
+// same settings, and has not been modified since.
+func (m *manifest) identical(dest string, src sourceFile) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+
+	e, ok := m.entries[dest]
+	if !ok || src.Path == "" {
+		return false
+	}
+	info, err := os.Stat(dest)
+	if err != nil {
+		return false
+	}
+	return e.Source == src.Path && e.SourceSize == src.Size && e.SourceModTime.Equal(src.ModTime) &&
+		e.Settings == src.Settings && e.OutputSize == info.Size() && e.OutputModTime.Equal(info.ModTime())
+}
+
+// record stores a finished conversion. The manifest is saved saveDelay
+// later, together with the conversions recorded in the meantime.
+func (m *manifest) record(dest string, src sourceFile) error {
+	info, err := os.Stat(dest)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+
+	m.entries[dest] = manifestEntry{
+		Source:        src.Path,
+		SourceSize:    src.Size,
+		SourceModTime: src.ModTime,
+		Settings:      src.Settings,
+		OutputSize:    info.Size(),
+		OutputModTime: info.ModTime(),
+	}
+	if m.path != "" && m.save == nil {
+		m.save = time.AfterFunc(saveDelay, m.flush)
+	}
+	return nil
+}
+
+// flush writes the changes that wait to be saved.
+func (m *manifest) flush() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.save == nil {
+		return
+	}
+	m.save.Stop()
+	m.save = nil
+	if err := writeJSONFile(m.path, m.entries); err != nil {
+		logger.Warn("Could not save manifest", "path", m.path, "error", err)
+	}
+}
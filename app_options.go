@@ -0,0 +1,396 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	goruntime "runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minjejeon/convert4share/converter"
+	"github.com/spf13/viper"
+)
+
+// JobOptions overrides the global settings for a single conversion.
+// Empty fields inherit the value from the preset, then from the settings.
+type JobOptions struct {
+	Preset          string `json:"preset,omitempty"`
+	VideoQuality    string `json:"videoQuality,omitempty"`
+	MaxSize         *int   `json:"maxSize,omitempty"`
+	VideoCodec      string `json:"videoCodec,omitempty"`
+	OutputDir       string `json:"outputDir,omitempty"`
+	CollisionOption string `json:"collisionOption,omitempty"`
+	// TrimStart and TrimEnd select a clip of a video, in seconds.
+	TrimStart float64 `json:"trimStart,omitempty"`
+	TrimEnd   float64 `json:"trimEnd,omitempty"`
+	// Rotate (clockwise degrees), FlipH, FlipV and Crop transform the picture.
+	Rotate int                 `json:"rotate,omitempty"`
+	FlipH  bool                `json:"flipH,omitempty"`
+	FlipV  bool                `json:"flipV,omitempty"`
+	Crop   *converter.CropRect `json:"crop,omitempty"`
+	// VideoMetadata is "keep", "dates" or "strip"; ImageMetadata is "keep",
+	// "no-gps", "minimal" or "strip".
+	VideoMetadata string `json:"videoMetadata,omitempty"`
+	ImageMetadata string `json:"imageMetadata,omitempty"`
+	// OutputTemplate names the output file, e.g. "{date}/{stem}_{preset}{ext}".
+	OutputTemplate string `json:"outputTemplate,omitempty"`
+	// DateFolders places outputs below capture date folders, e.g. "YYYY/MM".
+	DateFolders string `json:"dateFolders,omitempty"`
+	// SourceAction is "none", "trash", "archive" (to ArchiveDir) or "delete",
+	// applied to the original once the output is verified.
+	SourceAction string `json:"sourceAction,omitempty"`
+	ArchiveDir   string `json:"archiveDir,omitempty"`
+}
+
+// ConvertRequest is a single file submitted with its own options.
+type ConvertRequest struct {
+	File    string     `json:"file"`
+	Options JobOptions `json:"options"`
+	// Subdir is the folder of a file found by a folder scan, relative to
+	// the folder's parent, when the folder structure is mirrored.
+	Subdir string `json:"subdir,omitempty"`
+}
+
+// jobSpec is a conversion request with the preset and global settings applied.
+type jobSpec struct {
+	conv      *converter.Config
+	outputDir string
+	collision string
+	preset    string
+	// timestamps is the preserveTimestamps setting: "none", "source" or "capture".
+	timestamps string
+	template   string
+	// dateFolders is a pattern such as "YYYY/MM"; empty disables date folders.
+	dateFolders  string
+	sourceAction string
+	archiveDir   string
+}
+
+func intPtr(v int) *int { return &v }
+
+var builtinPresets = map[string]JobOptions{
+	"chat":    {VideoQuality: "low", MaxSize: intPtr(1280), VideoCodec: "h264"},
+	"archive": {VideoQuality: "high", MaxSize: intPtr(0), VideoCodec: "hevc"},
+}
+
+// overlay returns o with every non-empty field of over applied on top.
+func (o JobOptions) overlay(over JobOptions) JobOptions {
+	if over.Preset != "" {
+		o.Preset = over.Preset
+	}
+	if over.VideoQuality != "" {
+		o.VideoQuality = over.VideoQuality
+	}
+	if over.MaxSize != nil {
+		o.MaxSize = over.MaxSize
+	}
+	if over.VideoCodec != "" {
+		o.VideoCodec = over.VideoCodec
+	}
+	if over.OutputDir != "" {
+		o.OutputDir = over.OutputDir
+	}
+	if over.CollisionOption != "" {
+		o.CollisionOption = over.CollisionOption
+	}
+	if over.TrimStart != 0 {
+		o.TrimStart = over.TrimStart
+	}
+	if over.TrimEnd != 0 {
+		o.TrimEnd = over.TrimEnd
+	}
+	if over.Rotate != 0 {
+		o.Rotate = over.Rotate
+	}
+	if over.FlipH {
+		o.FlipH = true
+	}
+	if over.FlipV {
+		o.FlipV = true
+	}
+	if over.Crop != nil {
+		o.Crop = over.Crop
+	}
+	if over.VideoMetadata != "" {
+		o.VideoMetadata = over.VideoMetadata
+	}
+	if over.ImageMetadata != "" {
+		o.ImageMetadata = over.ImageMetadata
+	}
+	if over.OutputTemplate != "" {
+		o.OutputTemplate = over.OutputTemplate
+	}
+	if over.DateFolders != "" {
+		o.DateFolders = over.DateFolders
+	}
+	if over.SourceAction != "" {
+		o.SourceAction = over.SourceAction
+	}
+	if over.ArchiveDir != "" {
+		o.ArchiveDir = over.ArchiveDir
+	}
+	return o
+}
+
+// GetPresets returns the built-in presets merged with the ones defined under
+// `presets` in the config file. User presets replace built-ins of the same name.
+func (a *App) GetPresets() map[string]JobOptions {
+	presets := make(map[string]JobOptions, len(builtinPresets))
+	for name, p := range builtinPresets {
+		presets[name] = p
+	}
+
+	var custom map[string]JobOptions
+	if err := viper.UnmarshalKey("presets", &custom); err != nil {
+		logger.Warn("Could not read presets from config", "error", err)
+	}
+	for name, p := range custom {
+		presets[strings.ToLower(name)] = p
+	}
+	return presets
+}
+
+// newConverterConfig builds a converter configuration from the global settings.
+func newConverterConfig() *converter.Config {
+	conv := &converter.Config{
+		MagickBinary:        viper.GetString("magickBinary"),
+		FfmpegBinary:        viper.GetString("ffmpegBinary"),
+		MaxSize:             viper.GetInt("maxSize"),
+		HardwareAccelerator: viper.GetString("hardwareAccelerator"),
+		FfmpegCustomArgs:    viper.GetString("ffmpegCustomArgs"),
+		FfmpegInputArgs:     viper.GetString("ffmpegInputArgs"),
+		VideoQuality:        viper.GetString("videoQuality"),
+		VideoCodec:          viper.GetString("videoCodec"),
+		AudioBitrate:        viper.GetString("audioBitrate"),
+		AudioChannels:       viper.GetInt("audioChannels"),
+		AudioNormalize:      viper.GetBool("audioNormalize"),
+		AudioCopy:           viper.GetBool("audioCopy"),
+		AudioMute:           viper.GetBool("audioMute"),
+		VideoMetadata:       viper.GetString("videoMetadata"),
+		ImageMetadata:       viper.GetString("imageMetadata"),
+		StallTimeout:        time.Duration(viper.GetFloat64("stallTimeout") * float64(time.Second)),
+		TimeoutFactor:       viper.GetFloat64("timeoutFactor"),
+
+		FfmpegAcceleratorArgs: acceleratorArgs(),
+	}
+	applyResourceMode(conv, viper.GetString("resourceMode"))
+	return conv
+}
+
+// Resource modes set the priority and limits of the converter processes.
+const (
+	// resourceModeFast runs at normal priority without limits.
+	resourceModeFast = "fast"
+	// resourceModeBackground runs at low priority on half of the CPU cores
+	// and caps ImageMagick at 1GiB of memory, spilling to disk beyond it.
+	resourceModeBackground = "background"
+	// resourceModeCustom uses the individual settings.
+	resourceModeCustom = "custom"
+)
+
+// applyResourceMode sets the process priority and resource limits of conv.
+func applyResourceMode(conv *converter.Config, mode string) {
+	switch strings.ToLower(mode) {
+	case resourceModeBackground:
+		threads := max(1, goruntime.NumCPU()/2)
+		conv.Priority = converter.PriorityLow
+		conv.FfmpegThreads = threads
+		conv.MagickThreads = threads
+		conv.MagickMemoryLimit = "1GiB"
+	case resourceModeCustom:
+		conv.Priority = viper.GetString("processPriority")
+		conv.FfmpegThreads = viper.GetInt("ffmpegThreads")
+		conv.MagickThreads = viper.GetInt("magickThreads")
+		conv.MagickMemoryLimit = viper.GetString("magickMemoryLimit")
+		conv.MagickDiskLimit = viper.GetString("magickDiskLimit")
+	default:
+		conv.Priority = converter.PriorityNormal
+	}
+}
+
+// resolveJobSpec applies the requested preset and overrides on top of the
+// global settings.
+func (a *App) resolveJobSpec(opts JobOptions) (*jobSpec, error) {
+	if opts.Preset != "" {
+		preset, ok := a.GetPresets()[strings.ToLower(opts.Preset)]
+		if !ok {
+			return nil, fmt.Errorf("unknown preset: %s", opts.Preset)
+		}
+		opts = preset.overlay(opts)
+	}
+
+	spec := &jobSpec{
+		conv:       newConverterConfig(),
+		collision:  viper.GetString("collisionOption"),
+		preset:     strings.ToLower(opts.Preset),
+		timestamps: viper.GetString("preserveTimestamps"),
+		template:   viper.GetString("outputTemplate"),
+
+		dateFolders:  viper.GetString("dateFolders"),
+		sourceAction: viper.GetString("sourceAction"),
+		archiveDir:   os.ExpandEnv(viper.GetString("archiveDir")),
+	}
+
+	if opts.VideoQuality != "" {
+		spec.conv.VideoQuality = opts.VideoQuality
+	}
+	if opts.MaxSize != nil {
+		spec.conv.MaxSize = *opts.MaxSize
+	}
+	if opts.VideoCodec != "" {
+		spec.conv.VideoCodec = opts.VideoCodec
+	}
+	if opts.OutputDir != "" {
+		spec.outputDir = os.ExpandEnv(opts.OutputDir)
+	}
+	if opts.CollisionOption != "" {
+		spec.collision = opts.CollisionOption
+	}
+	spec.conv.TrimStart = time.Duration(opts.TrimStart * float64(time.Second))
+	spec.conv.TrimEnd = time.Duration(opts.TrimEnd * float64(time.Second))
+	spec.conv.Rotate = opts.Rotate
+	spec.conv.FlipH = opts.FlipH
+	spec.conv.FlipV = opts.FlipV
+	if opts.Crop != nil {
+		spec.conv.Crop = *opts.Crop
+	}
+	if opts.VideoMetadata != "" {
+		spec.conv.VideoMetadata = opts.VideoMetadata
+	}
+	if opts.ImageMetadata != "" {
+		spec.conv.ImageMetadata = opts.ImageMetadata
+	}
+	if opts.OutputTemplate != "" {
+		spec.template = opts.OutputTemplate
+	}
+	if opts.DateFolders != "" {
+		spec.dateFolders = opts.DateFolders
+	}
+	if opts.SourceAction != "" {
+		spec.sourceAction = opts.SourceAction
+	}
+	if opts.ArchiveDir != "" {
+		spec.archiveDir = os.ExpandEnv(opts.ArchiveDir)
+	}
+	if err := validateCollision(spec.collision); err != nil {
+		return nil, err
+	}
+	if err := validateSourceAction(spec.sourceAction, spec.archiveDir); err != nil {
+		return nil, err
+	}
+
+	return spec, nil
+}
+
+// parseLaunchArgs splits command line arguments into files and the options
+// preceding them. An option applies to every file after it until it is set
+// again, so one launch can request different conversions per file:
+//
+//	convert4share --preset chat a.mov --preset archive --codec hevc b.mov
+//
+// An invalid option is skipped and reported in the returned error; the files
+// are still returned with the options that were valid.
+func parseLaunchArgs(args []string) ([]ConvertRequest, error) {
+	var requests []ConvertRequest
+	var current JobOptions
+	var errs []error
+	optionsDone := false
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if optionsDone || !strings.HasPrefix(arg, "--") {
+			requests = append(requests, ConvertRequest{File: arg, Options: current})
+			continue
+		}
+		if arg == "--" {
+			optionsDone = true
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+		if !hasValue {
+			if i+1 >= len(args) {
+				errs = append(errs, fmt.Errorf("missing value for --%s", name))
+				break
+			}
+			i++
+			value = args[i]
+		}
+		if err := setLaunchOption(&current, name, value); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return requests, errors.Join(errs...)
+}
+
+// setLaunchOption sets the command line option --name to value in opts.
+// opts is left unchanged when the value is invalid.
+func setLaunchOption(opts *JobOptions, name, value string) error {
+	switch name {
+	case "preset":
+		opts.Preset = value
+	case "quality":
+		opts.VideoQuality = value
+	case "max-size":
+		size, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid --max-size %q: %w", value, err)
+		}
+		opts.MaxSize = intPtr(size)
+	case "codec":
+		opts.VideoCodec = value
+	case "out":
+		opts.OutputDir = value
+	case "collision":
+		if err := validateCollision(value); err != nil {
+			return err
+		}
+		opts.CollisionOption = value
+	case "start", "end":
+		at, err := converter.ParseTimecode(value)
+		if err != nil {
+			return fmt.Errorf("invalid --%s: %w", name, err)
+		}
+		if name == "start" {
+			opts.TrimStart = at.Seconds()
+		} else {
+			opts.TrimEnd = at.Seconds()
+		}
+	case "rotate":
+		deg, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid --rotate %q: %w", value, err)
+		}
+		opts.Rotate = deg
+	case "flip":
+		h, v := strings.Contains(value, "h"), strings.Contains(value, "v")
+		if !h && !v {
+			return fmt.Errorf("invalid --flip %q, expected h, v or hv", value)
+		}
+		opts.FlipH, opts.FlipV = h, v
+	case "crop":
+		rect, err := converter.ParseCropRect(value)
+		if err != nil {
+			return err
+		}
+		opts.Crop = &rect
+	case "video-metadata":
+		opts.VideoMetadata = value
+	case "image-metadata":
+		opts.ImageMetadata = value
+	case "template":
+		opts.OutputTemplate = value
+	case "date-folders":
+		opts.DateFolders = value
+	case "source-action":
+		opts.SourceAction = value
+	case "archive-dir":
+		opts.ArchiveDir = value
+	default:
+		return fmt.Errorf("unknown option --%s", name)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// poolThumbnail is the pool of thumbnail generation, which has no limit of
+// its own but counts against the CPU budget.
+const poolThumbnail = "thumbnail"
+
+// workerPool accounts for running work by weight, per pool and in total.
+// Limits can be changed at any time: running work keeps counting against the
+// pool it was started in, and new work starts once the usage is below the
+// new limit. A limit or budget of 0 means no limit.
+type workerPool struct {
+	mu     sync.Mutex
+	limits map[string]int
+	used   map[string]int
+	budget int
+	total  int
+	// changed is closed and replaced whenever capacity may have been freed.
+	changed chan struct{}
+}
+
+func newWorkerPool() *workerPool {
+	return &workerPool{
+		limits:  make(map[string]int),
+		used:    make(map[string]int),
+		changed: make(chan struct{}),
+	}
+}
+
+// setLimit changes the total weight that may run in pool.
+func (p *workerPool) setLimit(pool string, limit int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.limits[pool] = limit
+	p.notify()
+}
+
+// setBudget changes the total weight that may run across all pools.
+func (p *workerPool) setBudget(budget int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.budget = budget
+	p.notify()
+}
+
+// fits reports whether work of the given weight may start. Work heavier
+// than a limit still starts when nothing else runs, so it cannot be stuck.
+// The caller must hold p.mu.
+func (p *workerPool) fits(pool string, weight int) bool {
+	if limit := p.limits[pool]; limit > 0 && p.used[pool] > 0 && p.used[pool]+weight > limit {
+		return false
+	}
+	if p.budget > 0 && p.total > 0 && p.total+weight > p.budget {
+		return false
+	}
+	return true
+}
+
+// tryAcquire starts work of the given weight in pool and returns the
+// function that ends it, or nil when there is no room.
+func (p *workerPool) tryAcquire(pool string, weight int) func() {
+	if weight < 1 {
+		weight = 1
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.fits(pool, weight) {
+		return nil
+	}
+	p.used[pool] += weight
+	p.total += weight
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			p.used[pool] -= weight
+			p.total -= weight
+			p.notify()
+		})
+	}
+}
+
+// acquire waits until work of the given weight may start in pool.
+func (p *workerPool) acquire(ctx context.Context, pool string, weight int) (func(), error) {
+	for {
+		p.mu.Lock()
+		changed := p.changed
+		p.mu.Unlock()
+
+		if release := p.tryAcquire(pool, weight); release != nil {
+			return release, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// usage returns the weight running in pool.
+func (p *workerPool) usage(pool string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.used[pool]
+}
+
+// notify wakes the callers waiting in acquire. The caller must hold p.mu.
+func (p *workerPool) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/minjejeon/convert4share/converter"
+	"github.com/spf13/viper"
+)
+
+// RouteRule sends matching sources to a destination folder and, optionally,
+// converts them with a preset. All conditions that are set must match.
+type RouteRule struct {
+	Name string `json:"name" mapstructure:"name"`
+	// Glob matches the whole source path with "/" as separator: "*" and "?"
+	// stay within a folder, "**" crosses folders. Matching ignores case.
+	Glob string `json:"glob,omitempty" mapstructure:"glob"`
+	// Regex is searched anywhere in the source path, with "/" as separator.
+	Regex      string   `json:"regex,omitempty" mapstructure:"regex"`
+	Extensions []string `json:"extensions,omitempty" mapstructure:"extensions"`
+	MinSizeMB  float64  `json:"minSizeMB,omitempty" mapstructure:"minSizeMB"`
+	MaxSizeMB  float64  `json:"maxSizeMB,omitempty" mapstructure:"maxSizeMB"`
+	// MediaType is "video" or "image".
+	MediaType string `json:"mediaType,omitempty" mapstructure:"mediaType"`
+	// DestDir is the output folder; empty falls back to defaultDestDir.
+	DestDir string `json:"destDir,omitempty" mapstructure:"destDir"`
+	Preset  string `json:"preset,omitempty" mapstructure:"preset"`
+	// SourceAction and ArchiveDir override what happens to matching sources
+	// after conversion.
+	SourceAction string `json:"sourceAction,omitempty" mapstructure:"sourceAction"`
+	ArchiveDir   string `json:"archiveDir,omitempty" mapstructure:"archiveDir"`
+}
+
+// RouteResult describes the rule chosen for a source file. Rule is -1 when
+// no rule matches and the output goes next to the source.
+type RouteResult struct {
+	Rule         int    `json:"rule"`
+	Name         string `json:"name,omitempty"`
+	DestDir      string `json:"destDir"`
+	Preset       string `json:"preset,omitempty"`
+	SourceAction string `json:"sourceAction,omitempty"`
+	ArchiveDir   string `json:"archiveDir,omitempty"`
+}
+
+// compile checks the rule and returns its path matchers.
+func (r RouteRule) compile() (glob, re *regexp.Regexp, err error) {
+	if r.Glob != "" {
+		if glob, err = regexp.Compile("(?i)^" + globToRegex(filepath.ToSlash(r.Glob)) + "$"); err != nil {
+			return nil, nil, fmt.Errorf("rule %q: invalid glob: %w", r.Name, err)
+		}
+	}
+	if r.Regex != "" {
+		if re, err = regexp.Compile(r.Regex); err != nil {
+			return nil, nil, fmt.Errorf("rule %q: invalid regex: %w", r.Name, err)
+		}
+	}
+	switch strings.ToLower(r.MediaType) {
+	case "", "video", "image":
+	default:
+		return nil, nil, fmt.Errorf("rule %q: unknown media type %q, expected video or image", r.Name, r.MediaType)
+	}
+	if r.SourceAction != "" {
+		// The archive folder may also come from the settings.
+		archive := r.ArchiveDir
+		if archive == "" {
+			archive = viper.GetString("archiveDir")
+		}
+		if err := validateSourceAction(r.SourceAction, archive); err != nil {
+			return nil, nil, fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+	}
+	return glob, re, nil
+}
+
+// globToRegex translates a glob into a regular expression without anchors.
+func globToRegex(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				// "**/" also matches no folder at all.
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					i++
+					b.WriteString("(?:.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// compiledRoute is a rule with its path matchers compiled once for all
+// the sources of a batch. index is its position among the configured rules.
+type compiledRoute struct {
+	RouteRule
+	index    int
+	glob, re *regexp.Regexp
+}
+
+// compileRoutes compiles the rules in order. Rules with invalid patterns
+// are skipped.
+func compileRoutes(rules []RouteRule) []compiledRoute {
+	compiled := make([]compiledRoute, 0, len(rules))
+	for i, r := range rules {
+		glob, re, err := r.compile()
+		if err != nil {
+			logger.Warn("Skipping invalid route rule", "rule", r.Name, "error", err)
+			continue
+		}
+		compiled = append(compiled, compiledRoute{RouteRule: r, index: i, glob: glob, re: re})
+	}
+	return compiled
+}
+
+// matches reports whether the rule applies to the source path of the given size.
+func (r compiledRoute) matches(path string, size int64) bool {
+	slashPath := filepath.ToSlash(path)
+	if r.glob != nil && !r.glob.MatchString(slashPath) {
+		return false
+	}
+	if r.re != nil && !r.re.MatchString(slashPath) {
+		return false
+	}
+
+	if len(r.Extensions) > 0 {
+		ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+		found := false
+		for _, e := range r.Extensions {
+			if strings.TrimPrefix(strings.ToLower(e), ".") == ext {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	mb := float64(size) / (1024 * 1024)
+	if r.MinSizeMB > 0 && mb < r.MinSizeMB {
+		return false
+	}
+	if r.MaxSizeMB > 0 && mb > r.MaxSizeMB {
+		return false
+	}
+
+	switch strings.ToLower(r.MediaType) {
+	case "video":
+		return converter.IsVideoFile(path)
+	case "image":
+		return !converter.IsVideoFile(path)
+	}
+	return true
+}
+
+// routeRules reads the routing rules from the config.
+func routeRules() []RouteRule {
+	var rules []RouteRule
+	if err := viper.UnmarshalKey("routes", &rules); err != nil {
+		logger.Warn("Could not read routes from config", "error", err)
+	}
+	return rules
+}
+
+// validateRoutes checks every rule so that broken patterns are reported when
+// saving instead of when converting.
+func validateRoutes(rules []RouteRule) error {
+	for _, r := range rules {
+		if _, _, err := r.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// route returns the first rule matching the source.
+func route(rules []compiledRoute, path string, size int64) RouteResult {
+	for _, r := range rules {
+		if !r.matches(path, size) {
+			continue
+		}
+		dest := r.DestDir
+		if dest == "" {
+			dest = viper.GetString("defaultDestDir")
+		}
+		return RouteResult{
+			Rule:         r.index,
+			Name:         r.Name,
+			DestDir:      os.ExpandEnv(dest),
+			Preset:       r.Preset,
+			SourceAction: r.SourceAction,
+			ArchiveDir:   r.ArchiveDir,
+		}
+	}
+	return RouteResult{Rule: -1, DestDir: filepath.Dir(path)}
+}
+
+// TestRoute shows where a file would be converted to and which rule decides it.
+func (a *App) TestRoute(path string) RouteResult {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	var size int64
+	if info, err := os.Stat(path); err == nil {
+		size = info.Size()
+	}
+	return route(compileRoutes(routeRules()), path, size)
+}
+
+// migrateExcludePatterns turns the legacy excludeStringPatterns into routes
+// that send sources below those folders to defaultDestDir, as before. It
+// returns the number of migrated rules.
+func migrateExcludePatterns() int {
+	if viper.IsSet("routes") {
+		return 0
+	}
+	patterns := viper.GetStringSlice("excludeStringPatterns")
+	if len(patterns) == 0 {
+		return 0
+	}
+
+	rules := make([]RouteRule, 0, len(patterns))
+	for _, pat := range patterns {
+		if pat == "" {
+			continue
+		}
+		rules = append(rules, RouteRule{
+			Name: pat,
+			// The pattern used to be searched in the parent folder, so a "/"
+			// must follow it.
+			Regex: regexp.QuoteMeta(filepath.ToSlash(filepath.Clean(pat))) + ".*/",
+		})
+	}
+	viper.Set("routes", rules)
+	return len(rules)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/minjejeon/convert4share/converter"
+	"github.com/spf13/viper"
+)
+
+// Priority classes of queued jobs; lower classes are dispatched first.
+const (
+	priorityImage = iota
+	priorityVideo
+	priorityLongVideo
+)
+
+// Worker pools; each has its own limit.
+const (
+	poolFfmpeg = "ffmpeg"
+	poolMagick = "magick"
+)
+
+// sourcePool returns the worker pool that converts sources with extension
+// ext, or "" when the format is not supported.
+func sourcePool(ext string) string {
+	switch strings.ToLower(ext) {
+	case ".mov":
+		return poolFfmpeg
+	case ".heic":
+		return poolMagick
+	}
+	return ""
+}
+
+// queuedJob is a job waiting in the scheduler. start is closed when the job
+// may run. weight is its share of the worker pool.
+type queuedJob struct {
+	id      string
+	pool    string
+	class   int
+	weight  int
+	start   chan struct{}
+	release func()
+}
+
+func newQueuedJob(id, pool string, class, weight int) *queuedJob {
+	return &queuedJob{id: id, pool: pool, class: class, weight: weight, start: make(chan struct{})}
+}
+
+// scheduler decides which waiting job starts next. Jobs wait in dispatch
+// order: by priority class, then in the order they were queued, unless
+// moved with MoveJob. A job starts when its pool has room for its weight
+// and the queue is not paused; while paused, no job starts.
+type scheduler struct {
+	mu      sync.Mutex
+	paused  bool
+	waiting []*queuedJob
+	// acquire takes weight in pool and returns the function that frees it,
+	// or nil when the pool is full.
+	acquire func(pool string, weight int) func()
+}
+
+func newScheduler(acquire func(pool string, weight int) func()) *scheduler {
+	return &scheduler{acquire: acquire}
+}
+
+// add queues jobs behind all waiting jobs of the same or a lower class and
+// starts what can be started.
+func (s *scheduler) add(jobs ...*queuedJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, j := range jobs {
+		i := len(s.waiting)
+		for i > 0 && s.waiting[i-1].class > j.class {
+			i--
+		}
+		s.waiting = append(s.waiting, nil)
+		copy(s.waiting[i+1:], s.waiting[i:])
+		s.waiting[i] = j
+	}
+	s.dispatch()
+}
+
+// dispatch starts waiting jobs in order while their pools have room. The
+// first job that does not fit holds back the later jobs of its pool, so a
+// heavy job is not starved by lighter ones queued behind it, but it does
+// not hold back jobs of other pools. The caller must hold s.mu.
+func (s *scheduler) dispatch() {
+	if s.paused {
+		return
+	}
+	blocked := make(map[string]bool)
+	waiting := s.waiting[:0]
+	for _, j := range s.waiting {
+		if blocked[j.pool] {
+			waiting = append(waiting, j)
+			continue
+		}
+		if release := s.acquire(j.pool, j.weight); release != nil {
+			j.release = release
+			close(j.start)
+			continue
+		}
+		blocked[j.pool] = true
+		waiting = append(waiting, j)
+	}
+	for i := len(waiting); i < len(s.waiting); i++ {
+		s.waiting[i] = nil
+	}
+	s.waiting = waiting
+}
+
+// wake starts waiting jobs after pool limits changed.
+func (s *scheduler) wake() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dispatch()
+}
+
+// finish removes a job that ended, whether it ran or was cancelled while
+// waiting, and hands its share of the pool to the next ones.
+func (s *scheduler) finish(j *queuedJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if i := s.index(j.id); i >= 0 {
+		s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+	}
+	if j.release != nil {
+		j.release()
+		j.release = nil
+	}
+	s.dispatch()
+}
+
+// requeue puts a started job back at the front of the queue with a new
+// weight, once probing it showed its real size. It starts again as soon as
+// its pool has room for that weight.
+func (s *scheduler) requeue(j *queuedJob, weight int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if j.release != nil {
+		j.release()
+		j.release = nil
+	}
+	j.weight = weight
+	j.start = make(chan struct{})
+	s.waiting = append([]*queuedJob{j}, s.waiting...)
+	s.dispatch()
+}
+
+func (s *scheduler) setPaused(paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = paused
+	s.dispatch()
+}
+
+// move puts a waiting job at index among the waiting jobs, 0 being the next
+// to start. Out of range indexes are clamped.
+func (s *scheduler) move(id string, index int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.index(id)
+	if i < 0 {
+		return fmt.Errorf("job %s is not waiting", id)
+	}
+	j := s.waiting[i]
+	s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+
+	if index < 0 {
+		index = 0
+	}
+	if index > len(s.waiting) {
+		index = len(s.waiting)
+	}
+	s.waiting = append(s.waiting, nil)
+	copy(s.waiting[index+1:], s.waiting[index:])
+	s.waiting[index] = j
+	return nil
+}
+
+// order returns the IDs of the waiting jobs in dispatch order.
+func (s *scheduler) order() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]string, len(s.waiting))
+	for i, j := range s.waiting {
+		ids[i] = j.id
+	}
+	return ids
+}
+
+// index returns the position of a waiting job, or -1. The caller must hold
+// s.mu.
+func (s *scheduler) index(id string) int {
+	for i, j := range s.waiting {
+		if j.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// weightPixels is the output size of a video job of weight 1, Full HD.
+const weightPixels = 1920 * 1080
+
+// longVideoBytesPerMinute is the size of a minute of video assumed when a
+// job is queued, about what phones record in Full HD to 4K. Sources are not
+// probed before they are queued, so long videos are told by their size.
+const longVideoBytesPerMinute = 100 << 20
+
+// jobPriority returns the priority class of a source of the given size. With
+// queueOrder "fifo" every job has the same class; otherwise images go first
+// and videos estimated to last at least longVideoMinutes go last.
+func jobPriority(path string, size int64) int {
+	if !converter.IsVideoFile(path) {
+		return priorityImage
+	}
+	if viper.GetString("queueOrder") == "fifo" {
+		return priorityImage
+	}
+	minutes := viper.GetFloat64("longVideoMinutes")
+	if minutes > 0 && float64(size) >= minutes*longVideoBytesPerMinute {
+		return priorityLongVideo
+	}
+	return priorityVideo
+}
+
+// jobWeight probes a source for its share of the worker pool. Images weigh
+// 1, videos one per Full HD frame of output, so a 4K encode weighs 4.
+func jobWeight(ctx context.Context, conv *converter.Config, path string) int {
+	if !converter.IsVideoFile(path) {
+		return 1
+	}
+	info, err := conv.ProbeMedia(ctx, path)
+	if err != nil {
+		return 1
+	}
+	return videoWeight(info.Width, info.Height, conv.MaxSize)
+}
+
+// videoWeight returns the weight of encoding a w x h video scaled to fit
+// within maxSize.
+func videoWeight(w, h, maxSize int) int {
+	pixels := float64(w) * float64(h)
+	if long := max(w, h); maxSize > 0 && long > maxSize {
+		scale := float64(maxSize) / float64(long)
+		pixels *= scale * scale
+	}
+	return max(1, int(math.Round(pixels/weightPixels)))
+}
+
+// MoveJob moves a job that has not started yet to index in the queue, 0
+// being the next job to start.
+func (a *App) MoveJob(id string, index int) error {
+	return a.sched.move(id, index)
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 
+	"github.com/minjejeon/convert4share/converter"
 	"github.com/spf13/viper"
 )
 
@@ -14,11 +16,49 @@ type Settings struct {
 	MaxSize             int      `json:"maxSize"`
 	HardwareAccelerator string   `json:"hardwareAccelerator"`
 	FfmpegCustomArgs    string   `json:"ffmpegCustomArgs"`
+	FfmpegInputArgs     string   `json:"ffmpegInputArgs"`
 	DefaultDestDir      string   `json:"defaultDestDir"`
-	ExcludePatterns     []string `json:"excludePatterns"`
 	VideoQuality        string   `json:"videoQuality"`
+	VideoCodec          string   `json:"videoCodec"`
 	MaxFfmpegWorkers    int      `json:"maxFfmpegWorkers"`
+	CPUBudget           int      `json:"cpuBudget"`
+	ResourceMode        string   `json:"resourceMode"`
+	ProcessPriority     string   `json:"processPriority"`
+	FfmpegThreads       int      `json:"ffmpegThreads"`
+	MagickThreads       int      `json:"magickThreads"`
+	MagickMemoryLimit   string   `json:"magickMemoryLimit"`
+	MagickDiskLimit     string   `json:"magickDiskLimit"`
+	StallTimeout        float64  `json:"stallTimeout"`
+	TimeoutFactor       float64  `json:"timeoutFactor"`
+	MaxRetries          int      `json:"maxRetries"`
+	ContentCache        string   `json:"contentCache"`
 	CollisionOption     string   `json:"collisionOption"`
+	AudioBitrate        string   `json:"audioBitrate"`
+	AudioChannels       int      `json:"audioChannels"`
+	AudioNormalize      bool     `json:"audioNormalize"`
+	AudioCopy           bool     `json:"audioCopy"`
+	AudioMute           bool     `json:"audioMute"`
+	VideoMetadata       string   `json:"videoMetadata"`
+	ImageMetadata       string   `json:"imageMetadata"`
+	PreserveTimestamps  string   `json:"preserveTimestamps"`
+	OutputTemplate      string   `json:"outputTemplate"`
+	DateFolders         string   `json:"dateFolders"`
+	SourceAction        string   `json:"sourceAction"`
+	ArchiveDir          string   `json:"archiveDir"`
+	QueueOrder          string   `json:"queueOrder"`
+	LongVideoMinutes    float64  `json:"longVideoMinutes"`
+	FolderInclude       []string `json:"folderInclude"`
+	FolderExclude       []string `json:"folderExclude"`
+	FolderMaxDepth      int      `json:"folderMaxDepth"`
+	FolderSkipHidden    bool     `json:"folderSkipHidden"`
+	FolderSymlinks      string   `json:"folderSymlinks"`
+	MirrorFolders       bool     `json:"mirrorFolders"`
+	FolderConfirmCount  int      `json:"folderConfirmCount"`
+
+	// FfmpegAcceleratorArgs overrides the custom arguments per accelerator.
+	FfmpegAcceleratorArgs map[string]converter.CustomArgs `json:"ffmpegAcceleratorArgs"`
+	// Routes are checked in order; the first match decides the destination.
+	Routes []RouteRule `json:"routes"`
 }
 
 func (a *App) initConfig() {
@@ -39,9 +79,42 @@ func (a *App) initConfig() {
 	viper.SetDefault("maxSize", 1920)
 	viper.SetDefault("maxMagickWorkers", 5)
 	viper.SetDefault("maxFfmpegWorkers", 1)
+	viper.SetDefault("cpuBudget", 0)
+	viper.SetDefault("resourceMode", resourceModeFast)
+	viper.SetDefault("processPriority", converter.PriorityNormal)
+	viper.SetDefault("ffmpegThreads", 0)
+	viper.SetDefault("magickThreads", 0)
+	viper.SetDefault("magickMemoryLimit", "")
+	viper.SetDefault("magickDiskLimit", "")
+	viper.SetDefault("stallTimeout", 120)
+	viper.SetDefault("timeoutFactor", 10)
+	viper.SetDefault("maxRetries", 1)
+	viper.SetDefault("contentCache", contentCacheFast)
 	viper.SetDefault("hardwareAccelerator", "none")
 	viper.SetDefault("videoQuality", "high")
+	viper.SetDefault("videoCodec", "h264")
 	viper.SetDefault("collisionOption", "rename")
+	viper.SetDefault("audioBitrate", "")
+	viper.SetDefault("audioChannels", 0)
+	viper.SetDefault("audioNormalize", false)
+	viper.SetDefault("audioCopy", true)
+	viper.SetDefault("audioMute", false)
+	viper.SetDefault("videoMetadata", "keep")
+	viper.SetDefault("imageMetadata", "keep")
+	viper.SetDefault("preserveTimestamps", "none")
+	viper.SetDefault("outputTemplate", defaultOutputTemplate)
+	viper.SetDefault("dateFolders", "")
+	viper.SetDefault("sourceAction", sourceActionNone)
+	viper.SetDefault("archiveDir", "")
+	viper.SetDefault("queueOrder", "priority")
+	viper.SetDefault("longVideoMinutes", 10)
+	viper.SetDefault("folderInclude", []string{})
+	viper.SetDefault("folderExclude", []string{})
+	viper.SetDefault("folderMaxDepth", 0)
+	viper.SetDefault("folderSkipHidden", true)
+	viper.SetDefault("folderSymlinks", symlinksSkip)
+	viper.SetDefault("mirrorFolders", false)
+	viper.SetDefault("folderConfirmCount", 100)
 
 	defaultDest := "$HOMEDRIVE/$HOMEPATH/Pictures"
 	if home, err := os.UserHomeDir(); err == nil {
@@ -52,6 +125,16 @@ func (a *App) initConfig() {
 	if err := viper.ReadInConfig(); err != nil {
 		logger.Info("Config file not found, using defaults", "error", err)
 	}
+	a.manifest = newManifest(filepath.Join(exeDir, "manifest.json"))
+	a.cache = newContentCache(filepath.Join(exeDir, "cache.json"))
+	a.parts = newPartJournal(filepath.Join(exeDir, "parts.json"))
+	a.queuePath = filepath.Join(exeDir, "queue.json")
+	if n := a.parts.cleanup(); n > 0 {
+		logger.Info("Removed stale part files", "count", n)
+	}
+	if n := migrateExcludePatterns(); n > 0 {
+		logger.Info("Migrated excludeStringPatterns to routes", "routes", n)
+	}
 
 	detected := a.DetectBinaries()
 
@@ -81,10 +164,12 @@ func (a *App) initConfig() {
 		}
 	}
 
-	a.updateSemaphores()
+	a.updateWorkerLimits()
 }
 
-func (a *App) updateSemaphores() {
+// updateWorkerLimits applies the worker settings. Running jobs keep their
+// share; the new limits apply to the jobs that start next.
+func (a *App) updateWorkerLimits() {
 	maxFfmpeg := viper.GetInt("maxFfmpegWorkers")
 	if maxFfmpeg < 1 {
 		maxFfmpeg = 1
@@ -95,15 +180,43 @@ func (a *App) updateSemaphores() {
 		maxMagick = 1
 	}
 
-	a.mu.Lock()
-	defer a.mu.Unlock()
+	a.workers.setLimit(poolFfmpeg, maxFfmpeg)
+	a.workers.setLimit(poolMagick, maxMagick)
+	a.workers.setBudget(max(0, viper.GetInt("cpuBudget")))
+
+	// Higher limits may have room for waiting jobs.
+	a.sched.wake()
+}
 
-	if a.ffmpegSem == nil || cap(a.ffmpegSem) != maxFfmpeg {
-		a.ffmpegSem = make(chan struct{}, maxFfmpeg)
+// validateResourceSettings checks the resource mode and the custom process
+// priority and limits.
+func validateResourceSettings(s Settings) error {
+	switch s.ResourceMode {
+	case "", resourceModeFast, resourceModeBackground, resourceModeCustom:
+	default:
+		return fmt.Errorf("unknown resource mode %q, expected fast, background or custom", s.ResourceMode)
+	}
+	switch s.ProcessPriority {
+	case "", converter.PriorityNormal, converter.PriorityLow:
+	default:
+		return fmt.Errorf("unknown process priority %q, expected normal or low", s.ProcessPriority)
 	}
-	if a.magickSem == nil || cap(a.magickSem) != maxMagick {
-		a.magickSem = make(chan struct{}, maxMagick)
+	limits := converter.Config{
+		FfmpegThreads:     s.FfmpegThreads,
+		MagickThreads:     s.MagickThreads,
+		MagickMemoryLimit: s.MagickMemoryLimit,
+		MagickDiskLimit:   s.MagickDiskLimit,
 	}
+	return limits.ValidateResourceLimits()
+}
+
+// acceleratorArgs reads the per-accelerator custom ffmpeg arguments.
+func acceleratorArgs() map[string]converter.CustomArgs {
+	var args map[string]converter.CustomArgs
+	if err := viper.UnmarshalKey("ffmpegAcceleratorArgs", &args); err != nil {
+		logger.Warn("Could not read ffmpegAcceleratorArgs from config", "error", err)
+	}
+	return args
 }
 
 func (a *App) GetSettings() Settings {
@@ -113,25 +226,131 @@ func (a *App) GetSettings() Settings {
 		MaxSize:             viper.GetInt("maxSize"),
 		HardwareAccelerator: viper.GetString("hardwareAccelerator"),
 		FfmpegCustomArgs:    viper.GetString("ffmpegCustomArgs"),
+		FfmpegInputArgs:     viper.GetString("ffmpegInputArgs"),
 		DefaultDestDir:      viper.GetString("defaultDestDir"),
-		ExcludePatterns:     viper.GetStringSlice("excludeStringPatterns"),
 		VideoQuality:        viper.GetString("videoQuality"),
+		VideoCodec:          viper.GetString("videoCodec"),
 		MaxFfmpegWorkers:    viper.GetInt("maxFfmpegWorkers"),
+		CPUBudget:           viper.GetInt("cpuBudget"),
+		ResourceMode:        viper.GetString("resourceMode"),
+		ProcessPriority:     viper.GetString("processPriority"),
+		FfmpegThreads:       viper.GetInt("ffmpegThreads"),
+		MagickThreads:       viper.GetInt("magickThreads"),
+		MagickMemoryLimit:   viper.GetString("magickMemoryLimit"),
+		MagickDiskLimit:     viper.GetString("magickDiskLimit"),
+		StallTimeout:        viper.GetFloat64("stallTimeout"),
+		TimeoutFactor:       viper.GetFloat64("timeoutFactor"),
+		MaxRetries:          viper.GetInt("maxRetries"),
+		ContentCache:        viper.GetString("contentCache"),
 		CollisionOption:     viper.GetString("collisionOption"),
+		AudioBitrate:        viper.GetString("audioBitrate"),
+		AudioChannels:       viper.GetInt("audioChannels"),
+		AudioNormalize:      viper.GetBool("audioNormalize"),
+		AudioCopy:           viper.GetBool("audioCopy"),
+		AudioMute:           viper.GetBool("audioMute"),
+		VideoMetadata:       viper.GetString("videoMetadata"),
+		ImageMetadata:       viper.GetString("imageMetadata"),
+		PreserveTimestamps:  viper.GetString("preserveTimestamps"),
+		OutputTemplate:      viper.GetString("outputTemplate"),
+		DateFolders:         viper.GetString("dateFolders"),
+		SourceAction:        viper.GetString("sourceAction"),
+		ArchiveDir:          viper.GetString("archiveDir"),
+		QueueOrder:          viper.GetString("queueOrder"),
+		LongVideoMinutes:    viper.GetFloat64("longVideoMinutes"),
+		FolderInclude:       viper.GetStringSlice("folderInclude"),
+		FolderExclude:       viper.GetStringSlice("folderExclude"),
+		FolderMaxDepth:      viper.GetInt("folderMaxDepth"),
+		FolderSkipHidden:    viper.GetBool("folderSkipHidden"),
+		FolderSymlinks:      viper.GetString("folderSymlinks"),
+		MirrorFolders:       viper.GetBool("mirrorFolders"),
+		FolderConfirmCount:  viper.GetInt("folderConfirmCount"),
+
+		FfmpegAcceleratorArgs: acceleratorArgs(),
+		Routes:                routeRules(),
 	}
 }
 
 func (a *App) SaveSettings(s Settings) error {
+	check := converter.Config{
+		HardwareAccelerator:   s.HardwareAccelerator,
+		FfmpegInputArgs:       s.FfmpegInputArgs,
+		FfmpegCustomArgs:      s.FfmpegCustomArgs,
+		FfmpegAcceleratorArgs: s.FfmpegAcceleratorArgs,
+		AudioNormalize:        s.AudioNormalize,
+	}
+	if err := check.ValidateCustomArgs(); err != nil {
+		return err
+	}
+	if err := validateResourceSettings(s); err != nil {
+		return err
+	}
+	if err := validateContentCache(s.ContentCache); err != nil {
+		return err
+	}
+	if err := validateFolderSettings(s); err != nil {
+		return err
+	}
+	if _, err := renderTemplate(s.OutputTemplate, templateVars{Ext: ".mp4"}, 1); err != nil {
+		return err
+	}
+	if err := validateRoutes(s.Routes); err != nil {
+		return err
+	}
+	if err := validateSourceAction(s.SourceAction, s.ArchiveDir); err != nil {
+		return err
+	}
+	if err := validateCollision(s.CollisionOption); err != nil {
+		return err
+	}
+	if s.QueueOrder != "" && s.QueueOrder != "priority" && s.QueueOrder != "fifo" {
+		return fmt.Errorf("unknown queue order %q, expected priority or fifo", s.QueueOrder)
+	}
+
 	viper.Set("magickBinary", s.MagickBinary)
 	viper.Set("ffmpegBinary", s.FfmpegBinary)
 	viper.Set("maxSize", s.MaxSize)
 	viper.Set("hardwareAccelerator", s.HardwareAccelerator)
 	viper.Set("ffmpegCustomArgs", s.FfmpegCustomArgs)
+	viper.Set("ffmpegInputArgs", s.FfmpegInputArgs)
+	viper.Set("ffmpegAcceleratorArgs", s.FfmpegAcceleratorArgs)
+	viper.Set("routes", s.Routes)
 	viper.Set("defaultDestDir", s.DefaultDestDir)
-	viper.Set("excludeStringPatterns", s.ExcludePatterns)
 	viper.Set("videoQuality", s.VideoQuality)
+	viper.Set("videoCodec", s.VideoCodec)
 	viper.Set("maxFfmpegWorkers", s.MaxFfmpegWorkers)
+	viper.Set("cpuBudget", s.CPUBudget)
+	viper.Set("resourceMode", s.ResourceMode)
+	viper.Set("processPriority", s.ProcessPriority)
+	viper.Set("ffmpegThreads", s.FfmpegThreads)
+	viper.Set("magickThreads", s.MagickThreads)
+	viper.Set("magickMemoryLimit", s.MagickMemoryLimit)
+	viper.Set("magickDiskLimit", s.MagickDiskLimit)
+	viper.Set("stallTimeout", s.StallTimeout)
+	viper.Set("timeoutFactor", s.TimeoutFactor)
+	viper.Set("maxRetries", s.MaxRetries)
+	viper.Set("contentCache", s.ContentCache)
 	viper.Set("collisionOption", s.CollisionOption)
+	viper.Set("audioBitrate", s.AudioBitrate)
+	viper.Set("audioChannels", s.AudioChannels)
+	viper.Set("audioNormalize", s.AudioNormalize)
+	viper.Set("audioCopy", s.AudioCopy)
+	viper.Set("audioMute", s.AudioMute)
+	viper.Set("videoMetadata", s.VideoMetadata)
+	viper.Set("imageMetadata", s.ImageMetadata)
+	viper.Set("preserveTimestamps", s.PreserveTimestamps)
+	viper.Set("outputTemplate", s.OutputTemplate)
+	viper.Set("dateFolders", s.DateFolders)
+	viper.Set("sourceAction", s.SourceAction)
+	viper.Set("archiveDir", s.ArchiveDir)
+	viper.Set("queueOrder", s.QueueOrder)
+	viper.Set("longVideoMinutes", s.LongVideoMinutes)
+	viper.Set("folderInclude", s.FolderInclude)
+	viper.Set("folderExclude", s.FolderExclude)
+	viper.Set("folderMaxDepth", s.FolderMaxDepth)
+	viper.Set("folderSkipHidden", s.FolderSkipHidden)
+	viper.Set("folderSymlinks", s.FolderSymlinks)
+	viper.Set("mirrorFolders", s.MirrorFolders)
+	viper.Set("folderConfirmCount", s.FolderConfirmCount)
 
 	exePath, err := os.Executable()
 	if err != nil {
@@ -142,7 +361,7 @@ func (a *App) SaveSettings(s Settings) error {
 
 	err = viper.WriteConfigAs(configPath)
 	if err == nil {
-		a.updateSemaphores()
+		a.updateWorkerLimits()
 	}
 	return err
 }
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// Choices offered by the "confirm-close" event.
+const (
+	// closeWait lets running jobs finish, saves the others for the next
+	// launch and quits.
+	closeWait = "wait"
+	// closeCancel cancels every job, cleaning up its part file, and quits.
+	closeCancel = "cancel"
+	// closePersist saves the unfinished jobs for the next launch, then
+	// cancels them and quits.
+	closePersist = "persist"
+)
+
+// shutdownTimeout bounds how long shutdown waits for killed jobs to clean up.
+const shutdownTimeout = 10 * time.Second
+
+// CloseRequest is the payload of the "confirm-close" event.
+type CloseRequest struct {
+	Running int `json:"running"`
+	Waiting int `json:"waiting"`
+}
+
+// isRunning reports whether an unfinished job was started by the
+// scheduler, even if it has not begun converting yet.
+func isRunning(job *JobStatus) bool {
+	if !isUnfinished(job.Status) {
+		return false
+	}
+	return job.started || job.Status == "processing" || job.Status == "suspended"
+}
+
+// isUnfinished reports whether a job is running or has not started yet.
+func isUnfinished(status string) bool {
+	return status != "done" && status != "skipped" && status != "error"
+}
+
+// activeJobs counts the running jobs and the jobs that have not started.
+func (a *App) activeJobs() CloseRequest {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var req CloseRequest
+	for _, job := range a.jobs {
+		switch {
+		case isRunning(job):
+			req.Running++
+		case isUnfinished(job.Status):
+			req.Waiting++
+		}
+	}
+	return req
+}
+
+// cancelJobs cancels the unfinished jobs, or only those that have not
+// started when waitingOnly is set, and returns their requests in queue order.
+func (a *App) cancelJobs(waitingOnly bool) []ConvertRequest {
+	a.mu.Lock()
+	var ids []string
+	var requests []ConvertRequest
+	for _, id := range a.jobOrder {
+		job, ok := a.jobs[id]
+		if !ok || !isUnfinished(job.Status) || (waitingOnly && isRunning(job)) {
+			continue
+		}
+		ids = append(ids, id)
+		requests = append(requests, job.request)
+	}
+	a.mu.Unlock()
+
+	for _, id := range ids {
+		a.CancelJob(id)
+	}
+	return requests
+}
+
+// resumeSuspended resumes every suspended job.
+func (a *App) resumeSuspended() {
+	a.mu.Lock()
+	var ids []string
+	for id, ctl := range a.jobControls {
+		if ctl.Suspended() {
+			ids = append(ids, id)
+		}
+	}
+	a.mu.Unlock()
+
+	for _, id := range ids {
+		if err := a.ResumeJob(id); err != nil {
+			logger.Warn("Could not resume job before closing", "id", id, "error", err)
+		}
+	}
+}
+
+// ConfirmClose answers the "confirm-close" event with "wait", "cancel" or
+// "persist". The window closes once the remaining jobs have ended and their
+// processes have exited.
+func (a *App) ConfirmClose(choice string) error {
+	switch choice {
+	case closeWait:
+		a.setClosing()
+		// Nothing else may start while the running jobs finish.
+		a.sched.setPaused(true)
+		requests := a.cancelJobs(true)
+		if err := a.saveQueue(requests); err != nil {
+			logger.Error("Could not save the queue", "path", a.queuePath, "error", err)
+		}
+		// A suspended job would never finish.
+		a.resumeSuspended()
+	case closeCancel:
+		a.setClosing()
+		a.cancelJobs(false)
+	case closePersist:
+		a.setClosing()
+		requests := a.cancelJobs(false)
+		if err := a.saveQueue(requests); err != nil {
+			logger.Error("Could not save the queue", "path", a.queuePath, "error", err)
+		}
+	default:
+		return fmt.Errorf("unknown close choice %q, expected wait, cancel or persist", choice)
+	}
+
+	go func() {
+		a.jobsWG.Wait()
+		runtime.Quit(a.ctx)
+	}()
+	return nil
+}
+
+// setClosing lets the window close and stops new jobs from being queued.
+// Folder scans waiting for confirmation are dropped.
+func (a *App) setClosing() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.closing = true
+	clear(a.folderScans)
+}
+
+// saveQueue writes the requests to the queue file for restoreQueue.
+func (a *App) saveQueue(requests []ConvertRequest) error {
+	if a.queuePath == "" || len(requests) == 0 {
+		return nil
+	}
+	for i := range requests {
+		if abs, err := filepath.Abs(requests[i].File); err == nil {
+			requests[i].File = abs
+		}
+	}
+	data, err := json.MarshalIndent(requests, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(a.queuePath, data, 0644)
+}
+
+// loadQueue reads and removes the queue saved by a previous run.
+func (a *App) loadQueue() []ConvertRequest {
+	if a.queuePath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(a.queuePath)
+	if err != nil {
+		return nil
+	}
+	os.Remove(a.queuePath)
+
+	var requests []ConvertRequest
+	if err := json.Unmarshal(data, &requests); err != nil {
+		logger.Warn("Ignoring unreadable saved queue", "path", a.queuePath, "error", err)
+		return nil
+	}
+	return requests
+}
+
+// restoreQueue queues the jobs saved when the app was last closed.
+func (a *App) restoreQueue() {
+	if requests := a.loadQueue(); len(requests) > 0 {
+		logger.Info("Restoring saved queue", "jobs", len(requests))
+		a.ConvertFilesWithOptions(requests)
+	}
+}
+
+// waitForJobs waits until every job goroutine has returned, which happens
+// after their converter processes have exited, or until the timeout passes.
+func (a *App) waitForJobs(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		a.jobsWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/minjejeon/convert4share/windows"
+)
+
+// Actions applied to the source file after a successful conversion.
+const (
+	sourceActionNone    = "none"
+	sourceActionTrash   = "trash"
+	sourceActionArchive = "archive"
+	sourceActionDelete  = "delete"
+)
+
+// validateSourceAction checks a sourceAction value and its archive folder.
+func validateSourceAction(action, archiveDir string) error {
+	switch strings.ToLower(action) {
+	case "", sourceActionNone, sourceActionTrash, sourceActionDelete:
+		return nil
+	case sourceActionArchive:
+		if archiveDir == "" {
+			return fmt.Errorf("source action archive requires an archive folder")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown source action %q, expected none, trash, archive or delete", action)
+}
+
+// verifyOutput is the safety check run before touching the source: the
+// output must be a non-empty regular file distinct from the source.
+func verifyOutput(src, dest string) error {
+	out, err := os.Stat(dest)
+	if err != nil {
+		return fmt.Errorf("output missing: %w", err)
+	}
+	if !out.Mode().IsRegular() || out.Size() == 0 {
+		return fmt.Errorf("output %s is empty", dest)
+	}
+	if in, err := os.Stat(src); err == nil && os.SameFile(in, out) {
+		return fmt.Errorf("output %s is the source file", dest)
+	}
+	return nil
+}
+
+// applySourceAction trashes, archives or deletes the source of a finished
+// job. verified is the result of verifying the output; the source is left
+// untouched unless it is nil.
+func applySourceAction(spec *jobSpec, src string, verified error) error {
+	action := strings.ToLower(spec.sourceAction)
+	if action == "" || action == sourceActionNone {
+		return nil
+	}
+	if verified != nil {
+		return fmt.Errorf("keeping source, output failed verification: %w", verified)
+	}
+
+	switch action {
+	case sourceActionTrash:
+		return windows.MoveToTrash(src)
+	case sourceActionArchive:
+		if spec.archiveDir == "" {
+			return errors.New("no archive folder configured")
+		}
+		if err := os.MkdirAll(spec.archiveDir, 0755); err != nil {
+			return err
+		}
+		_, err := moveToDir(src, spec.archiveDir)
+		return err
+	case sourceActionDelete:
+		return os.Remove(src)
+	}
+	return fmt.Errorf("unknown source action %q", spec.sourceAction)
+}
+
+// moveToDir moves src into dir, adding " (N)" to the name when it is taken.
+// Moves across devices fall back to copying.
+func moveToDir(src, dir string) (string, error) {
+	name := filepath.Base(src)
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+
+	dst := filepath.Join(dir, name)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(dst); os.IsNotExist(err) {
+			break
+		}
+		dst = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", stem, i, ext))
+	}
+
+	if err := os.Rename(src, dst); err == nil {
+		return dst, nil
+	}
+	if err := copyFile(src, dst); err != nil {
+		os.Remove(dst)
+		return "", err
+	}
+	return dst, os.Remove(src)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
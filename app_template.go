@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const defaultOutputTemplate = "{stem}{ext}"
+
+// maxComponentLen is the longest file or folder name, in UTF-16 units,
+// accepted by FAT32, exFAT and NTFS.
+const maxComponentLen = 255
+
+var (
+	templateTokenRegex = regexp.MustCompile(`\{(\w+)(?::([^{}]*))?\}`)
+	reservedCharsRegex = regexp.MustCompile(`[<>:"/\\|?*\x00-\x1f]`)
+	reservedNames      = map[string]bool{
+		"CON": true, "PRN": true, "AUX": true, "NUL": true,
+		"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+		"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+	}
+)
+
+// templateVars are the values available to output filename templates.
+// Width, Height and CaptureDate are filled only when the template uses them,
+// since they require probing the source.
+type templateVars struct {
+	Stem        string
+	Ext         string
+	Preset      string
+	Now         time.Time
+	CaptureDate time.Time
+	Width       int
+	Height      int
+}
+
+// renderTemplate expands the tokens of an output template into a relative
+// path ending in vars.Ext. Supported tokens are {stem}, {ext}, {preset},
+// {date:<layout>}, {capture_date:<layout>}, {width}, {height} and
+// {counter:<digits>}, where layouts use Go's reference time and default to
+// 2006-01-02. Every path component is sanitized for Windows and FAT32.
+func renderTemplate(tmpl string, vars templateVars, counter int) (string, error) {
+	if tmpl == "" {
+		tmpl = defaultOutputTemplate
+	}
+
+	var renderErr error
+	rendered := templateTokenRegex.ReplaceAllStringFunc(tmpl, func(token string) string {
+		m := templateTokenRegex.FindStringSubmatch(token)
+		name, arg := m[1], m[2]
+		layout := arg
+		if layout == "" {
+			layout = "2006-01-02"
+		}
+
+		switch name {
+		case "stem":
+			return vars.Stem
+		case "ext":
+			return vars.Ext
+		case "preset":
+			return vars.Preset
+		case "date":
+			return vars.Now.Format(layout)
+		case "capture_date":
+			return vars.CaptureDate.Format(layout)
+		case "width":
+			return strconv.Itoa(vars.Width)
+		case "height":
+			return strconv.Itoa(vars.Height)
+		case "counter":
+			digits, _ := strconv.Atoi(arg)
+			return fmt.Sprintf("%0*d", digits, counter)
+		}
+		renderErr = fmt.Errorf("unknown template token %s", token)
+		return token
+	})
+	if renderErr != nil {
+		return "", renderErr
+	}
+
+	// Templates may use either separator; layouts such as 2006/01 create folders.
+	parts := strings.FieldsFunc(rendered, func(r rune) bool { return r == '/' || r == '\\' })
+	var clean []string
+	for i, part := range parts {
+		if part == "." || part == ".." {
+			continue
+		}
+		ext := ""
+		if i == len(parts)-1 {
+			ext = vars.Ext
+		}
+		clean = append(clean, sanitizeComponent(part, ext))
+	}
+	if len(clean) == 0 {
+		return "", fmt.Errorf("template %q renders an empty file name", tmpl)
+	}
+
+	name := clean[len(clean)-1]
+	if !strings.HasSuffix(strings.ToLower(name), strings.ToLower(vars.Ext)) {
+		clean[len(clean)-1] = sanitizeComponent(name+vars.Ext, vars.Ext)
+	}
+	return filepath.Join(clean...), nil
+}
+
+// sanitizeComponent replaces characters that are reserved on Windows,
+// avoids reserved device names and trims the name to maxComponentLen while
+// keeping ext intact.
+func sanitizeComponent(name, ext string) string {
+	name = reservedCharsRegex.ReplaceAllString(name, "_")
+	name = strings.TrimRight(name, " .")
+	if name == "" {
+		name = "_"
+	}
+
+	base := name
+	if i := strings.IndexByte(base, '.'); i >= 0 {
+		base = base[:i]
+	}
+	if reservedNames[strings.ToUpper(base)] {
+		name = "_" + name
+	}
+
+	if utf16Len(name) <= maxComponentLen {
+		return name
+	}
+	suffix := ""
+	if ext != "" && strings.HasSuffix(strings.ToLower(name), strings.ToLower(ext)) {
+		suffix = name[len(name)-len(ext):]
+		name = name[:len(name)-len(ext)]
+	}
+	runes := []rune(name)
+	for utf16Len(string(runes))+utf16Len(suffix) > maxComponentLen {
+		runes = runes[:len(runes)-1]
+	}
+	return strings.TrimRight(string(runes), " .") + suffix
+}
+
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// dateFolderLayout converts a date folder pattern such as "YYYY/MM" or
+// "YYYY-MM-DD" into a Go time layout.
+func dateFolderLayout(pattern string) string {
+	return strings.NewReplacer("YYYY", "2006", "MM", "01", "DD", "02").Replace(pattern)
+}
+
+// templateVarsFor collects the values an output template needs for src.
+func templateVarsFor(ctx context.Context, tmpl string, spec *jobSpec, src, ext string) templateVars {
+	fname := filepath.Base(src)
+	vars := templateVars{
+		Stem:   strings.TrimSuffix(fname, filepath.Ext(fname)),
+		Ext:    ext,
+		Preset: spec.preset,
+		Now:    time.Now(),
+	}
+
+	if strings.Contains(tmpl, "{capture_date") {
+		if t, _, err := spec.conv.CaptureDate(ctx, src); err == nil {
+			vars.CaptureDate = t
+		}
+	}
+
+	if strings.Contains(tmpl, "{width}") || strings.Contains(tmpl, "{height}") {
+		w, h, err := spec.conv.OutputSize(ctx, src)
+		if err != nil {
+			logger.Warn("Could not determine output size for template", "file", src, "error", err)
+		}
+		vars.Width, vars.Height = w, h
+	}
+
+	return vars
+}
+
+// resolveOutput renders the output template for src and reserves the
+// resulting path under dir. Template subdirectories are not created here but
+// by makeOutputDir once the job writes its output.
+// With date folders, the output goes below a folder named after the capture
+// date.
+// Templates containing {counter} resolve collisions by incrementing the
+// counter instead of appending " (N)".
+func (a *App) resolveOutput(ctx context.Context, dir string, source sourceFile, ext string, spec *jobSpec) (string, error) {
+	src := source.Path
+	tmpl := spec.template
+	if tmpl == "" {
+		tmpl = defaultOutputTemplate
+	}
+	if spec.dateFolders != "" {
+		tmpl = "{capture_date:" + dateFolderLayout(spec.dateFolders) + "}/" + tmpl
+	}
+	vars := templateVarsFor(ctx, tmpl, spec, src, ext)
+
+	if !strings.Contains(tmpl, "{counter") {
+		rel, err := renderTemplate(tmpl, vars, 0)
+		if err != nil {
+			return "", err
+		}
+		full := filepath.Join(dir, rel)
+		base := filepath.Base(full)
+		return a.resolveDestination(filepath.Dir(full), base[:len(base)-len(ext)], ext, spec.collision, source)
+	}
+
+	for counter := 1; ; counter++ {
+		rel, err := renderTemplate(tmpl, vars, counter)
+		if err != nil {
+			return "", err
+		}
+		full := filepath.Join(dir, rel)
+		base := filepath.Base(full)
+		collision := spec.collision
+		if collision == "rename" || collision == "" {
+			// A taken name moves on to the next counter value.
+			collision = "error"
+		}
+		dest, err := a.resolveDestination(filepath.Dir(full), base[:len(base)-len(ext)], ext, collision, source)
+		if err == nil || collision != "error" || spec.collision == "error" {
+			return dest, err
+		}
+	}
+}
@@ -1,9 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	goruntime "runtime"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/minjejeon/convert4share/converter"
+	"github.com/spf13/viper"
 )
 
 func TestResolveDestination(t *testing.T) {
@@ -20,7 +30,7 @@ func TestResolveDestination(t *testing.T) {
 	ext := ".mp4"
 	expected := filepath.Join(tempDir, "testfile.mp4")
 
-	dest, err := app.resolveDestination(tempDir, name, ext, "rename")
+	dest, err := app.resolveDestination(tempDir, name, ext, "rename", sourceFile{})
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
@@ -35,7 +45,7 @@ func TestResolveDestination(t *testing.T) {
 	}
 
 	expectedRename := filepath.Join(tempDir, "testfile (1).mp4")
-	dest, err = app.resolveDestination(tempDir, name, ext, "rename")
+	dest, err = app.resolveDestination(tempDir, name, ext, "rename", sourceFile{})
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
@@ -48,7 +58,7 @@ func TestResolveDestination(t *testing.T) {
 		t.Fatalf("Failed to create file: %v", err)
 	}
     expectedRename2 := filepath.Join(tempDir, "testfile (2).mp4")
-	dest, err = app.resolveDestination(tempDir, name, ext, "rename")
+	dest, err = app.resolveDestination(tempDir, name, ext, "rename", sourceFile{})
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
@@ -58,7 +68,7 @@ func TestResolveDestination(t *testing.T) {
 
 
 	// Case 3: File exists, overwrite
-	dest, err = app.resolveDestination(tempDir, name, ext, "overwrite")
+	dest, err = app.resolveDestination(tempDir, name, ext, "overwrite", sourceFile{})
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
@@ -67,7 +77,7 @@ func TestResolveDestination(t *testing.T) {
 	}
 
 	// Case 4: File exists, error
-	_, err = app.resolveDestination(tempDir, name, ext, "error")
+	_, err = app.resolveDestination(tempDir, name, ext, "error", sourceFile{})
 	if err == nil {
 		t.Error("Expected error, got nil")
 	}
@@ -75,7 +85,8 @@ func TestResolveDestination(t *testing.T) {
     // Case 5: File does not exist, error option (should proceed)
     // Delete the file first
     os.Remove(expected)
-    dest, err = app.resolveDestination(tempDir, name, ext, "error")
+    app.releaseDestination(expected)
+    dest, err = app.resolveDestination(tempDir, name, ext, "error", sourceFile{})
     if err != nil {
         t.Errorf("Unexpected error: %v", err)
     }
@@ -83,15 +94,1143 @@ func TestResolveDestination(t *testing.T) {
         t.Errorf("Expected %s, got %s", expected, dest)
     }
 
-	// Case 6: File exists but is 0 bytes (should overwrite even if rename)
+	// Case 6: File exists but is 0 bytes (taken like any other file)
+	app.releaseDestination(expected)
 	if err := os.WriteFile(expected, []byte(""), 0644); err != nil {
 		t.Fatalf("Failed to create 0-byte file: %v", err)
 	}
-	dest, err = app.resolveDestination(tempDir, name, ext, "rename")
+	expectedRename3 := filepath.Join(tempDir, "testfile (3).mp4")
+	dest, err = app.resolveDestination(tempDir, name, ext, "rename", sourceFile{})
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
-	if dest != expected {
-		t.Errorf("Expected %s (overwrite 0-byte), got %s", expected, dest)
+	if dest != expectedRename3 {
+		t.Errorf("Expected %s (0-byte file kept), got %s", expectedRename3, dest)
+	}
+
+	// Case 6b: A name reserved by a running job is taken even if not on disk
+	expectedRename4 := filepath.Join(tempDir, "testfile (4).mp4")
+	dest, err = app.resolveDestination(tempDir, name, ext, "rename", sourceFile{})
+	if err != nil || dest != expectedRename4 {
+		t.Errorf("Expected %s next to the reserved %s, got %s, %v", expectedRename4, expectedRename3, dest, err)
+	}
+	app.releaseDestination(expectedRename3)
+	dest, err = app.resolveDestination(tempDir, name, ext, "rename", sourceFile{})
+	if err != nil || dest != expectedRename3 {
+		t.Errorf("Expected released %s to be reused, got %s, %v", expectedRename3, dest, err)
+	}
+
+	// Case 7: File exists, skip
+	if err := os.WriteFile(expected, []byte("test"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	var skipped *skippedError
+	dest, err = app.resolveDestination(tempDir, name, ext, "skip", sourceFile{})
+	if !errors.As(err, &skipped) || dest != expected {
+		t.Errorf("Expected skip of %s, got %s, %v", expected, dest, err)
+	}
+
+	// Case 8: skip-if-identical skips only what the manifest recorded
+	src := sourceFile{Path: "/photos/testfile.mov", Size: 42, ModTime: time.Now(), Settings: "s1"}
+	dest, err = app.resolveDestination(tempDir, name, ext, "skip-if-identical", src)
+	if err != nil || dest == expected {
+		t.Errorf("Expected rename without a manifest entry, got %s, %v", dest, err)
+	}
+	os.Remove(dest)
+	if err := app.manifest.record(expected, src); err != nil {
+		t.Fatalf("Failed to record manifest: %v", err)
+	}
+	dest, err = app.resolveDestination(tempDir, name, ext, "skip-if-identical", src)
+	if !errors.As(err, &skipped) || dest != expected {
+		t.Errorf("Expected skip of identical conversion, got %s, %v", dest, err)
+	}
+	changed := src
+	changed.Settings = "s2"
+	dest, err = app.resolveDestination(tempDir, name, ext, "skip-if-identical", changed)
+	if err != nil || dest == expected {
+		t.Errorf("Expected rename when settings changed, got %s, %v", dest, err)
+	}
+	os.Remove(dest)
+
+	// Case 9: replace-if-older compares modification times
+	outputTime := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(expected, outputTime, outputTime); err != nil {
+		t.Fatalf("Failed to set output time: %v", err)
+	}
+	dest, err = app.resolveDestination(tempDir, name, ext, "replace-if-older", sourceFile{ModTime: time.Now()})
+	if err != nil || dest != expected {
+		t.Errorf("Expected newer source to replace %s, got %s, %v", expected, dest, err)
+	}
+	app.releaseDestination(expected)
+	dest, err = app.resolveDestination(tempDir, name, ext, "replace-if-older", sourceFile{ModTime: outputTime.Add(-time.Hour)})
+	if !errors.As(err, &skipped) || dest != expected {
+		t.Errorf("Expected older source to be skipped, got %s, %v", dest, err)
+	}
+}
+
+func TestParseLaunchArgs(t *testing.T) {
+	requests, err := parseLaunchArgs([]string{"a.mov", "--preset", "chat", "b.mov", "--preset=archive", "--max-size", "720", "c.heic", "--", "--odd.mov"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(requests) != 4 {
+		t.Fatalf("Expected 4 requests, got %d", len(requests))
+	}
+	if requests[0].File != "a.mov" || requests[0].Options.Preset != "" {
+		t.Errorf("Expected a.mov without options, got %+v", requests[0])
+	}
+	if requests[1].File != "b.mov" || requests[1].Options.Preset != "chat" {
+		t.Errorf("Expected b.mov with chat preset, got %+v", requests[1])
+	}
+	if requests[2].Options.Preset != "archive" || requests[2].Options.MaxSize == nil || *requests[2].Options.MaxSize != 720 {
+		t.Errorf("Expected c.heic with archive preset and max size 720, got %+v", requests[2])
+	}
+	if requests[3].File != "--odd.mov" {
+		t.Errorf("Expected --odd.mov as a file after --, got %+v", requests[3])
+	}
+
+	// A bad option is reported and skipped; the files are still converted.
+	requests, err = parseLaunchArgs([]string{"--bogus", "x", "a.mov", "--preset", "chat", "--max-size", "big", "b.mov"})
+	if err == nil {
+		t.Error("Expected error for unknown option")
+	}
+	if len(requests) != 2 || requests[0].File != "a.mov" || requests[1].File != "b.mov" {
+		t.Fatalf("Expected a.mov and b.mov despite bad options, got %+v", requests)
+	}
+	if requests[1].Options.Preset != "chat" || requests[1].Options.MaxSize != nil {
+		t.Errorf("Expected b.mov with the chat preset only, got %+v", requests[1].Options)
+	}
+	requests, err = parseLaunchArgs([]string{"--collision", "skp", "a.mov"})
+	if err == nil || len(requests) != 1 || requests[0].Options.CollisionOption != "" {
+		t.Errorf("Expected an unknown collision option to be skipped, got %+v, %v", requests, err)
+	}
+	requests, err = parseLaunchArgs([]string{"a.mov", "--quality"})
+	if err == nil {
+		t.Error("Expected error for missing value")
+	}
+	if len(requests) != 1 {
+		t.Errorf("Expected a.mov despite the missing value, got %+v", requests)
+	}
+}
+
+func TestResolveJobSpec(t *testing.T) {
+	app := NewApp()
+	viper.Set("videoQuality", "high")
+	viper.Set("maxSize", 1920)
+	viper.Set("collisionOption", "rename")
+	defer viper.Reset()
+
+	spec, err := app.resolveJobSpec(JobOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if spec.conv.VideoQuality != "high" || spec.conv.MaxSize != 1920 || spec.collision != "rename" {
+		t.Errorf("Expected global settings, got %+v", spec.conv)
+	}
+
+	spec, err = app.resolveJobSpec(JobOptions{Preset: "chat", MaxSize: intPtr(640), CollisionOption: "overwrite"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if spec.conv.VideoQuality != "low" {
+		t.Errorf("Expected preset quality low, got %s", spec.conv.VideoQuality)
+	}
+	if spec.conv.MaxSize != 640 {
+		t.Errorf("Expected explicit max size to override preset, got %d", spec.conv.MaxSize)
+	}
+	if spec.collision != "overwrite" {
+		t.Errorf("Expected collision overwrite, got %s", spec.collision)
+	}
+
+	if _, err := app.resolveJobSpec(JobOptions{Preset: "missing"}); err == nil {
+		t.Error("Expected error for unknown preset")
+	}
+	if _, err := app.resolveJobSpec(JobOptions{CollisionOption: "overwirte"}); err == nil {
+		t.Error("Expected error for unknown collision option")
+	}
+}
+
+func TestPreserveTimestamps(t *testing.T) {
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "source.mov")
+	dest := filepath.Join(tempDir, "source.mp4")
+	for _, p := range []string{src, dest} {
+		if err := os.WriteFile(p, []byte("data"), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	srcTime := time.Date(2023, 5, 17, 10, 30, 0, 0, time.UTC)
+	if err := os.Chtimes(src, srcTime, srcTime); err != nil {
+		t.Fatalf("Failed to set source time: %v", err)
+	}
+
+	spec := &jobSpec{timestamps: "none"}
+	if err := preserveTimestamps(context.Background(), spec, src, dest); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if info, _ := os.Stat(dest); info.ModTime().Equal(srcTime) {
+		t.Error("Expected timestamps to be left alone when disabled")
+	}
+
+	spec.timestamps = "source"
+	if err := preserveTimestamps(context.Background(), spec, src, dest); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if info, _ := os.Stat(dest); !info.ModTime().Equal(srcTime) {
+		t.Errorf("Expected modification time %s, got %s", srcTime, info.ModTime())
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	vars := templateVars{
+		Stem:        "IMG_0001",
+		Ext:         ".mp4",
+		Preset:      "chat",
+		Now:         time.Date(2024, 3, 5, 12, 0, 0, 0, time.UTC),
+		CaptureDate: time.Date(2023, 12, 31, 23, 59, 0, 0, time.UTC),
+		Width:       1280,
+		Height:      720,
+	}
+
+	tests := []struct {
+		tmpl    string
+		counter int
+		want    string
+	}{
+		{"", 0, "IMG_0001.mp4"},
+		{"{stem}_{preset}{ext}", 0, "IMG_0001_chat.mp4"},
+		{"{date}_{stem}", 0, "2024-03-05_IMG_0001.mp4"},
+		{"{capture_date:2006/01}/{stem}{ext}", 0, filepath.Join("2023", "12", "IMG_0001.mp4")},
+		{"{stem}_{width}x{height}{ext}", 0, "IMG_0001_1280x720.mp4"},
+		{"{stem}_{counter:3}{ext}", 7, "IMG_0001_007.mp4"},
+		{"../{stem}{ext}", 0, "IMG_0001.mp4"},
+		{"{stem}: {date:15:04}?{ext}", 0, "IMG_0001_ 12_00_.mp4"},
+		{"con{ext}", 0, "_con.mp4"},
+	}
+	for _, tt := range tests {
+		got, err := renderTemplate(tt.tmpl, vars, tt.counter)
+		if err != nil {
+			t.Errorf("renderTemplate(%q) unexpected error: %v", tt.tmpl, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("renderTemplate(%q) = %q, want %q", tt.tmpl, got, tt.want)
+		}
+	}
+
+	if _, err := renderTemplate("{stem}_{unknown}", vars, 0); err == nil {
+		t.Error("Expected error for unknown token")
+	}
+}
+
+func TestSanitizeComponent(t *testing.T) {
+	long := strings.Repeat("가", 300) + ".mp4"
+	got := sanitizeComponent(long, ".mp4")
+	if utf16Len(got) > maxComponentLen {
+		t.Errorf("Expected at most %d UTF-16 units, got %d", maxComponentLen, utf16Len(got))
+	}
+	if !strings.HasSuffix(got, ".mp4") {
+		t.Errorf("Expected extension to be kept, got %q", got)
+	}
+}
+
+func TestResolveOutput(t *testing.T) {
+	app := NewApp()
+	tempDir := t.TempDir()
+	spec := &jobSpec{preset: "chat", collision: "rename", template: "{preset}/{stem}_{counter:2}{ext}"}
+
+	dest, err := app.resolveOutput(context.Background(), tempDir, sourceFile{Path: "/photos/clip.mov"}, ".mp4", spec)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := filepath.Join(tempDir, "chat", "clip_01.mp4"); dest != want {
+		t.Errorf("Expected %s, got %s", want, dest)
+	}
+	if _, err := os.Stat(filepath.Dir(dest)); !os.IsNotExist(err) {
+		t.Errorf("Expected no folder to be created before the job runs, got %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		t.Fatalf("Failed to create folder: %v", err)
+	}
+	if err := os.WriteFile(dest, []byte("test"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	dest, err = app.resolveOutput(context.Background(), tempDir, sourceFile{Path: "/photos/clip.mov"}, ".mp4", spec)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := filepath.Join(tempDir, "chat", "clip_02.mp4"); dest != want {
+		t.Errorf("Expected counter to be incremented to %s, got %s", want, dest)
+	}
+
+	spec.collision = "error"
+	if _, err := app.resolveOutput(context.Background(), tempDir, sourceFile{Path: "/photos/clip.mov"}, ".mp4", spec); err == nil {
+		t.Error("Expected error when the first counter value is taken")
+	}
+}
+
+func TestMakeOutputDir(t *testing.T) {
+	app := NewApp()
+	tempDir := t.TempDir()
+	dest := filepath.Join(tempDir, "2024", "03", "a.jpg")
+
+	removeDirs, err := app.makeOutputDir(dest)
+	if err != nil {
+		t.Fatalf("makeOutputDir failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Dir(dest)); err != nil {
+		t.Fatalf("Expected the output folder to exist: %v", err)
+	}
+
+	// A folder where another job reserved its output is kept.
+	other := filepath.Join(tempDir, "2024", "04", "b.jpg")
+	app.reserved[other] = struct{}{}
+	removeDirs()
+	if _, err := os.Stat(filepath.Join(tempDir, "2024", "03")); !os.IsNotExist(err) {
+		t.Errorf("Expected the empty month folder to be removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "2024")); err != nil {
+		t.Errorf("Expected the year folder reserved by another job to be kept, got %v", err)
+	}
+
+	delete(app.reserved, other)
+	os.Remove(filepath.Join(tempDir, "2024"))
+	removeDirs, _ = app.makeOutputDir(dest)
+	removeDirs()
+	if _, err := os.Stat(filepath.Join(tempDir, "2024")); !os.IsNotExist(err) {
+		t.Errorf("Expected the created folders to be removed, got %v", err)
+	}
+	if _, err := os.Stat(tempDir); err != nil {
+		t.Errorf("Expected the existing folder to be kept, got %v", err)
+	}
+}
+
+func TestRoute(t *testing.T) {
+	viper.Set("defaultDestDir", "/out/default")
+	defer viper.Reset()
+
+	rules := []RouteRule{
+		{Name: "large videos", MediaType: "video", MinSizeMB: 100, DestDir: "/out/large", Preset: "archive"},
+		{Name: "camera", Glob: "**/dcim/**/*.heic", DestDir: "/out/camera"},
+		{Name: "drive", Regex: "Google Drive/", Extensions: []string{".mov"}},
+	}
+
+	tests := []struct {
+		path     string
+		size     int64
+		wantRule int
+		wantDest string
+	}{
+		{"/home/me/DCIM/100APPLE/IMG_0001.HEIC", 1024, 1, "/out/camera"},
+		{"/home/me/DCIM/IMG_0001.mov", 200 << 20, 0, "/out/large"},
+		{"/home/me/Google Drive/clip.mov", 1024, 2, "/out/default"},
+		{"/home/me/Google Drive/photo.heic", 1024, -1, "/home/me/Google Drive"},
+		{"/home/me/Desktop/photo.heic", 1024, -1, "/home/me/Desktop"},
+	}
+	routes := compileRoutes(rules)
+	for _, tt := range tests {
+		got := route(routes, filepath.FromSlash(tt.path), tt.size)
+		if got.Rule != tt.wantRule || filepath.ToSlash(got.DestDir) != tt.wantDest {
+			t.Errorf("route(%q) = rule %d, dest %s; want rule %d, dest %s", tt.path, got.Rule, got.DestDir, tt.wantRule, tt.wantDest)
+		}
+	}
+	if got := route(routes, filepath.FromSlash("/v/a.mov"), 200<<20); got.Preset != "archive" {
+		t.Errorf("Expected preset archive, got %q", got.Preset)
+	}
+
+	if err := validateRoutes([]RouteRule{{Name: "bad", Regex: "("}}); err == nil {
+		t.Error("Expected error for invalid regex")
+	}
+}
+
+func TestMigrateExcludePatterns(t *testing.T) {
+	viper.Set("excludeStringPatterns", []string{"Some Cloud/Photos"})
+	viper.Set("defaultDestDir", "/out")
+	defer viper.Reset()
+
+	if n := migrateExcludePatterns(); n != 1 {
+		t.Fatalf("Expected 1 migrated rule, got %d", n)
+	}
+	rules := routeRules()
+	if len(rules) != 1 {
+		t.Fatalf("Expected 1 migrated rule, got %d", len(rules))
+	}
+
+	if got := route(compileRoutes(rules), filepath.FromSlash("/home/Some Cloud/Photos/2024/a.heic"), 1); got.DestDir != "/out" {
+		t.Errorf("Expected file below the pattern to go to /out, got %s", got.DestDir)
+	}
+	if got := route(compileRoutes(rules), filepath.FromSlash("/home/Some Cloud/Photos.heic"), 1); got.Rule != -1 {
+		t.Errorf("Expected pattern in the file name not to match, got rule %d", got.Rule)
+	}
+}
+
+func TestResolveOutput_DateFolders(t *testing.T) {
+	app := NewApp()
+	tempDir := t.TempDir()
+	spec := &jobSpec{conv: &converter.Config{}, collision: "rename", dateFolders: "YYYY/MM"}
+
+	// Without metadata the date comes from the file name.
+	dest, err := app.resolveOutput(context.Background(), tempDir, sourceFile{Path: "/photos/IMG_20240315_101010.heic"}, ".jpg", spec)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := filepath.Join(tempDir, "2024", "03", "IMG_20240315_101010.jpg"); dest != want {
+		t.Errorf("Expected %s, got %s", want, dest)
+	}
+}
+
+func TestApplySourceAction(t *testing.T) {
+	tempDir := t.TempDir()
+	newPair := func(name string) (string, string) {
+		src := filepath.Join(tempDir, name+".mov")
+		dest := filepath.Join(tempDir, name+".mp4")
+		for _, p := range []string{src, dest} {
+			if err := os.WriteFile(p, []byte("data"), 0644); err != nil {
+				t.Fatalf("Failed to create file: %v", err)
+			}
+		}
+		return src, dest
+	}
+	exists := func(p string) bool {
+		_, err := os.Stat(p)
+		return err == nil
+	}
+
+	// A failed verification keeps the source.
+	src, dest := newPair("unverified")
+	os.WriteFile(dest, nil, 0644)
+	spec := &jobSpec{sourceAction: sourceActionDelete}
+	if err := applySourceAction(spec, src, verifyOutput(src, dest)); err == nil || !exists(src) {
+		t.Errorf("Expected source to be kept when verification fails, got %v", err)
+	}
+
+	src, dest = newPair("deleted")
+	if err := applySourceAction(spec, src, verifyOutput(src, dest)); err != nil || exists(src) {
+		t.Errorf("Expected source to be deleted, got %v", err)
+	}
+
+	archive := filepath.Join(tempDir, "archive")
+	spec = &jobSpec{sourceAction: sourceActionArchive, archiveDir: archive}
+	for i, want := range []string{"archived.mov", "archived (1).mov"} {
+		src, dest = newPair("archived")
+		if err := applySourceAction(spec, src, verifyOutput(src, dest)); err != nil {
+			t.Fatalf("Archive %d failed: %v", i, err)
+		}
+		if exists(src) || !exists(filepath.Join(archive, want)) {
+			t.Errorf("Expected source to be moved to %s", want)
+		}
+	}
+
+	if goruntime.GOOS == "linux" {
+		t.Setenv("XDG_DATA_HOME", filepath.Join(tempDir, "data"))
+		spec = &jobSpec{sourceAction: sourceActionTrash}
+		src, dest = newPair("trashed")
+		if err := applySourceAction(spec, src, verifyOutput(src, dest)); err != nil {
+			t.Fatalf("Trash failed: %v", err)
+		}
+		trash := filepath.Join(tempDir, "data", "Trash")
+		if exists(src) || !exists(filepath.Join(trash, "files", "trashed.mov")) {
+			t.Error("Expected source to be moved to the trash")
+		}
+		info, err := os.ReadFile(filepath.Join(trash, "info", "trashed.mov.trashinfo"))
+		if err != nil || !strings.Contains(string(info), "Path="+filepath.ToSlash(src)) {
+			t.Errorf("Expected trashinfo with the original path, got %q, %v", info, err)
+		}
+	}
+
+	if err := validateSourceAction(sourceActionArchive, ""); err == nil {
+		t.Error("Expected archive without a folder to be rejected")
+	}
+}
+
+func TestPartJournal(t *testing.T) {
+	tempDir := t.TempDir()
+	dest := filepath.Join(tempDir, "IMG_0001.jpg")
+	part := partPath(dest, "abc123")
+	if filepath.Base(part) != ".c4s-abc123.part.jpg" || !isPartFile(part) {
+		t.Errorf("Unexpected part path %s", part)
+	}
+
+	journal := filepath.Join(tempDir, "parts.json")
+	j := newPartJournal(journal)
+	if err := os.WriteFile(part, []byte("half"), 0644); err != nil {
+		t.Fatalf("Failed to create part file: %v", err)
+	}
+	j.add(part)
+	j.add(dest) // never removed, as it is not named like a part file
+	if err := os.WriteFile(dest, []byte("done"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	// A new journal on the same file plays the next startup.
+	if n := newPartJournal(journal).cleanup(); n != 1 {
+		t.Errorf("Expected 1 stale part file to be removed, got %d", n)
+	}
+	if _, err := os.Stat(part); !os.IsNotExist(err) {
+		t.Error("Expected stale part file to be removed")
+	}
+	if _, err := os.Stat(dest); err != nil {
+		t.Error("Expected regular files to be left alone")
+	}
+	if n := newPartJournal(journal).cleanup(); n != 0 {
+		t.Errorf("Expected the journal to be reset, got %d", n)
+	}
+}
+
+func TestClearCompletedJobs(t *testing.T) {
+	app := NewApp()
+	for _, job := range []JobStatus{
+		{ID: "a", File: "same.mov", Status: "done"},
+		{ID: "b", File: "same.mov", Status: "processing"},
+		{ID: "c", File: "other.heic", Status: "skipped"},
+		{ID: "d", File: "other.heic", Status: "error"},
+	} {
+		job := job
+		app.jobs[job.ID] = &job
+		app.jobOrder = append(app.jobOrder, job.ID)
+	}
+
+	app.ClearCompletedJobs()
+	jobs := app.GetJobs()
+	if len(jobs) != 2 || jobs[0].ID != "b" || jobs[1].ID != "d" {
+		t.Errorf("Expected jobs b and d to remain in order, got %+v", jobs)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	workers := newWorkerPool()
+	workers.setLimit(poolFfmpeg, 1)
+	workers.setLimit(poolMagick, 1)
+	s := newScheduler(workers.tryAcquire)
+	started := func(j *queuedJob) bool {
+		select {
+		case <-j.start:
+			return true
+		default:
+			return false
+		}
+	}
+
+	s.setPaused(true)
+	video := newQueuedJob("video", poolFfmpeg, priorityVideo, 1)
+	long := newQueuedJob("long", poolFfmpeg, priorityLongVideo, 1)
+	image := newQueuedJob("image", poolMagick, priorityImage, 1)
+	image2 := newQueuedJob("image2", poolMagick, priorityImage, 1)
+	s.add(long, video, image, image2)
+
+	if got := strings.Join(s.order(), ","); got != "image,image2,video,long" {
+		t.Errorf("Expected images before videos before long videos, got %s", got)
+	}
+	if started(image) || started(video) {
+		t.Error("Expected no job to start while paused")
+	}
+
+	if err := s.move("long", 0); err != nil {
+		t.Fatalf("move failed: %v", err)
+	}
+	if err := s.move("missing", 0); err == nil {
+		t.Error("Expected moving an unknown job to fail")
+	}
+
+	s.setPaused(false)
+	if !started(long) || !started(image) {
+		t.Error("Expected the first job of each pool to start")
+	}
+	if started(video) || started(image2) {
+		t.Error("Expected full pools to hold the other jobs")
+	}
+
+	// A cancelled waiting job leaves the queue without taking a slot.
+	s.finish(video)
+	s.finish(long)
+	if got := strings.Join(s.order(), ","); got != "image2" {
+		t.Errorf("Expected only image2 to wait, got %s", got)
+	}
+	if n := workers.usage(poolFfmpeg); n != 0 {
+		t.Errorf("Expected the ffmpeg pool to be idle, got %d", n)
+	}
+
+	s.finish(image)
+	if !started(image2) {
+		t.Error("Expected image2 to start once a magick slot is free")
+	}
+	s.finish(image2)
+
+	// A heavy job that does not fit holds back the lighter jobs behind it.
+	workers.setLimit(poolFfmpeg, 4)
+	light := newQueuedJob("light", poolFfmpeg, priorityVideo, 1)
+	heavy := newQueuedJob("heavy", poolFfmpeg, priorityVideo, 4)
+	light2 := newQueuedJob("light2", poolFfmpeg, priorityVideo, 1)
+	light3 := newQueuedJob("light3", poolFfmpeg, priorityVideo, 1)
+	image3 := newQueuedJob("image3", poolMagick, priorityVideo, 1)
+	s.add(light, heavy, light2, light3, image3)
+	if !started(light) || started(heavy) {
+		t.Fatal("Expected the light job to start and the heavy job to wait")
+	}
+	if started(light2) || started(light3) {
+		t.Error("Expected the waiting heavy job to hold back the lighter jobs")
+	}
+	if !started(image3) {
+		t.Error("Expected the heavy job not to hold back other pools")
+	}
+	s.finish(light)
+	if !started(heavy) || started(light2) {
+		t.Error("Expected the heavy job to start before the lighter jobs")
+	}
+	s.finish(heavy)
+	s.finish(image3)
+
+	// A started job found to be heavier waits again at the front.
+	if !started(light2) || !started(light3) {
+		t.Fatal("Expected the light jobs to start")
+	}
+	s.requeue(light2, 4)
+	if started(light2) {
+		t.Error("Expected the reweighed job to wait for room")
+	}
+	late := newQueuedJob("late", poolFfmpeg, priorityVideo, 1)
+	s.add(late)
+	if got := strings.Join(s.order(), ","); got != "light2,late" {
+		t.Errorf("Expected the reweighed job before later jobs, got %s", got)
+	}
+	s.finish(light3)
+	if !started(light2) || started(late) {
+		t.Error("Expected the reweighed job to start once its pool is free")
+	}
+}
+
+func TestSchedulerHeavyJob(t *testing.T) {
+	workers := newWorkerPool()
+	workers.setLimit(poolFfmpeg, 2)
+	s := newScheduler(workers.tryAcquire)
+	started := func(j *queuedJob) bool {
+		select {
+		case <-j.start:
+			return true
+		default:
+			return false
+		}
+	}
+
+	// A 4K job weighs more than the whole limit, yet is not starved by the
+	// Full HD jobs around it.
+	first := newQueuedJob("first", poolFfmpeg, priorityVideo, 1)
+	heavy := newQueuedJob("4k", poolFfmpeg, priorityVideo, 4)
+	light := newQueuedJob("light", poolFfmpeg, priorityVideo, 1)
+	s.add(first, heavy, light)
+	if !started(first) || started(heavy) || started(light) {
+		t.Fatal("Expected only the first job to run while the 4K job waits")
+	}
+	s.finish(first)
+	if !started(heavy) || started(light) {
+		t.Fatal("Expected the 4K job to run on its own once the pool is idle")
+	}
+	if n := workers.usage(poolFfmpeg); n != 4 {
+		t.Errorf("Expected the 4K job to use 4 units, got %d", n)
+	}
+	s.finish(heavy)
+	if !started(light) {
+		t.Error("Expected the next job to start after the 4K job")
+	}
+}
+
+func TestJobPriority(t *testing.T) {
+	defer viper.Reset()
+	viper.Set("longVideoMinutes", 10)
+	if got := jobPriority("a.heic", 50<<20); got != priorityImage {
+		t.Errorf("Expected an image class, got %d", got)
+	}
+	if got := jobPriority("a.mov", 200<<20); got != priorityVideo {
+		t.Errorf("Expected a video class for a small video, got %d", got)
+	}
+	if got := jobPriority("a.mov", 2<<30); got != priorityLongVideo {
+		t.Errorf("Expected a long video class for a large video, got %d", got)
+	}
+	viper.Set("queueOrder", "fifo")
+	if got := jobPriority("a.mov", 2<<30); got != priorityImage {
+		t.Errorf("Expected one class with fifo, got %d", got)
+	}
+}
+
+func TestSuspendJob(t *testing.T) {
+	app := NewApp()
+	app.jobs["a"] = &JobStatus{ID: "a", File: "clip.mov", Status: "queued"}
+	if err := app.SuspendJob("a"); err == nil {
+		t.Error("Expected suspending a job that is not running to fail")
+	}
+
+	ctl := &converter.Control{}
+	app.jobControls["a"] = ctl
+	app.jobs["a"].Status = "processing"
+	if err := app.SuspendJob("a"); err != nil {
+		t.Fatalf("SuspendJob failed: %v", err)
+	}
+	if !ctl.Suspended() || app.jobs["a"].Status != "suspended" {
+		t.Errorf("Expected a suspended job, got %q", app.jobs["a"].Status)
+	}
+
+	// A late progress line must not hide the suspension.
+	app.reportProgress("a", "clip.mp4", 40, "2x", time.Minute)
+	if job := app.jobs["a"]; job.Status != "suspended" || job.Progress != 40 || job.ETA != 60 {
+		t.Errorf("Expected progress on a suspended job, got %+v", *job)
+	}
+
+	if err := app.ResumeJob("a"); err != nil {
+		t.Fatalf("ResumeJob failed: %v", err)
+	}
+	if ctl.Suspended() || app.jobs["a"].Status != "processing" {
+		t.Errorf("Expected a running job, got %q", app.jobs["a"].Status)
+	}
+}
+
+func TestWorkerPool(t *testing.T) {
+	p := newWorkerPool()
+	p.setLimit(poolFfmpeg, 4)
+
+	// Work heavier than the limit runs alone rather than never.
+	huge := p.tryAcquire(poolFfmpeg, 8)
+	if huge == nil {
+		t.Fatal("Expected heavy work to start in an idle pool")
+	}
+	if p.tryAcquire(poolFfmpeg, 1) != nil {
+		t.Error("Expected a full pool to refuse work")
+	}
+	huge()
+	huge() // releasing twice must not free more than was taken
+
+	uhd := p.tryAcquire(poolFfmpeg, 4)
+	if uhd == nil || p.tryAcquire(poolFfmpeg, 1) != nil {
+		t.Fatal("Expected a weight of 4 to fill a limit of 4")
+	}
+
+	// Lowering the limit keeps the running work counted.
+	p.setLimit(poolFfmpeg, 2)
+	uhd()
+	hd := p.tryAcquire(poolFfmpeg, 1)
+	hd2 := p.tryAcquire(poolFfmpeg, 1)
+	if hd == nil || hd2 == nil || p.tryAcquire(poolFfmpeg, 1) != nil {
+		t.Error("Expected the new limit of 2 to apply")
+	}
+
+	// The budget is shared by all pools.
+	p.setBudget(3)
+	img := p.tryAcquire(poolMagick, 1)
+	if img == nil || p.tryAcquire(poolThumbnail, 1) != nil {
+		t.Error("Expected the budget of 3 to be used up")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		release, err := p.acquire(ctx, poolThumbnail, 1)
+		if err == nil {
+			release()
+		}
+		done <- err
+	}()
+	img()
+	if err := <-done; err != nil {
+		t.Errorf("Expected the thumbnail to start once the image finished, got %v", err)
+	}
+	hd()
+	hd2()
+	if n := p.usage(poolFfmpeg); n != 0 {
+		t.Errorf("Expected an idle pool, got %d", n)
+	}
+}
+
+func TestVideoWeight(t *testing.T) {
+	tests := []struct {
+		w, h, maxSize int
+		want          int
+	}{
+		{1920, 1080, 0, 1},
+		{3840, 2160, 0, 4},
+		{3840, 2160, 1920, 1},
+		{1280, 720, 0, 1},
+		{2560, 1440, 0, 2},
+	}
+	for _, tt := range tests {
+		if got := videoWeight(tt.w, tt.h, tt.maxSize); got != tt.want {
+			t.Errorf("videoWeight(%d, %d, %d) = %d, want %d", tt.w, tt.h, tt.maxSize, got, tt.want)
+		}
+	}
+}
+
+func TestApplyResourceMode(t *testing.T) {
+	viper.Set("processPriority", "low")
+	viper.Set("ffmpegThreads", 3)
+	viper.Set("magickMemoryLimit", "512MiB")
+	defer viper.Reset()
+
+	conv := &converter.Config{}
+	applyResourceMode(conv, resourceModeFast)
+	if conv.Priority != converter.PriorityNormal || conv.FfmpegThreads != 0 || conv.MagickMemoryLimit != "" {
+		t.Errorf("Expected no limits in fast mode, got %+v", conv)
+	}
+
+	conv = &converter.Config{}
+	applyResourceMode(conv, resourceModeBackground)
+	if conv.Priority != converter.PriorityLow || conv.FfmpegThreads < 1 || conv.MagickThreads < 1 || conv.MagickMemoryLimit == "" {
+		t.Errorf("Expected low priority and limits in background mode, got %+v", conv)
+	}
+
+	conv = &converter.Config{}
+	applyResourceMode(conv, resourceModeCustom)
+	if conv.Priority != "low" || conv.FfmpegThreads != 3 || conv.MagickMemoryLimit != "512MiB" {
+		t.Errorf("Expected the custom settings, got %+v", conv)
+	}
+
+	if err := validateResourceSettings(Settings{ResourceMode: "turbo"}); err == nil {
+		t.Error("Expected an unknown resource mode to be rejected")
+	}
+	if err := validateResourceSettings(Settings{ResourceMode: resourceModeCustom, MagickDiskLimit: "huge"}); err == nil {
+		t.Error("Expected an invalid disk limit to be rejected")
+	}
+}
+
+func TestCloseJobs(t *testing.T) {
+	app := NewApp()
+	app.queuePath = filepath.Join(t.TempDir(), "queue.json")
+	for _, job := range []JobStatus{
+		{ID: "a", File: "/in/done.mov", Status: "done"},
+		{ID: "b", File: "/in/running.mov", Status: "processing"},
+		{ID: "c", File: "/in/paused.mov", Status: "suspended"},
+		{ID: "d", File: "/in/waiting.heic", Status: "pending"},
+		{ID: "e", File: "/in/new.heic", Status: "queued"},
+		{ID: "f", File: "/in/started.mov", Status: "pending", started: true},
+	} {
+		job := job
+		job.request = ConvertRequest{File: job.File, Options: JobOptions{Preset: "small"}}
+		app.jobs[job.ID] = &job
+		app.jobOrder = append(app.jobOrder, job.ID)
+	}
+
+	// A job the scheduler started counts as running before it converts.
+	if got := app.activeJobs(); got.Running != 3 || got.Waiting != 2 {
+		t.Errorf("Expected 3 running and 2 waiting jobs, got %+v", got)
+	}
+
+	// Waiting only cancels the jobs that have not started.
+	cancelled := app.cancelJobs(true)
+	if len(cancelled) != 2 || cancelled[0].File != "/in/waiting.heic" || cancelled[1].File != "/in/new.heic" {
+		t.Errorf("Expected the waiting jobs to be cancelled in order, got %+v", cancelled)
+	}
+	if got := app.activeJobs(); got.Running != 3 || got.Waiting != 0 {
+		t.Errorf("Expected only the running jobs to remain, got %+v", got)
+	}
+
+	// Waiting for the running jobs resumes the suspended ones, which would
+	// otherwise never finish.
+	ctl := &converter.Control{}
+	if err := ctl.Suspend(); err != nil {
+		t.Fatalf("Suspend failed: %v", err)
+	}
+	app.jobControls["b"] = &converter.Control{}
+	app.jobControls["c"] = ctl
+	app.resumeSuspended()
+	if ctl.Suspended() || app.jobs["c"].Status != "processing" {
+		t.Errorf("Expected the suspended job to be resumed, got %q", app.jobs["c"].Status)
+	}
+
+	requests := app.cancelJobs(false)
+	if err := app.saveQueue(requests); err != nil {
+		t.Fatalf("saveQueue failed: %v", err)
+	}
+	restored := app.loadQueue()
+	if len(restored) != 3 || restored[0].Options.Preset != "small" {
+		t.Errorf("Expected the running jobs to be saved with their options, got %+v", restored)
+	}
+	if _, err := os.Stat(app.queuePath); !os.IsNotExist(err) {
+		t.Error("Expected the saved queue to be removed once loaded")
+	}
+	if err := app.ConfirmClose("later"); err == nil {
+		t.Error("Expected an unknown choice to be rejected")
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+	viper.Set("maxRetries", 1)
+	defer viper.Reset()
+
+	stalled := fmt.Errorf("%w. Log: ...", &converter.TimeoutError{Stalled: true, After: time.Minute})
+	if !shouldRetry(stalled, 1) {
+		t.Error("Expected a stalled conversion to be retried once")
+	}
+	if shouldRetry(stalled, 2) {
+		t.Error("Expected no retry beyond maxRetries")
+	}
+	if shouldRetry(errors.New("ffmpeg finished with error"), 1) {
+		t.Error("Expected other errors not to be retried")
+	}
+	if shouldRetry(nil, 1) {
+		t.Error("Expected success not to be retried")
+	}
+}
+
+func TestContentCache(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 3*hashChunk)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	a := filepath.Join(dir, "a.mov")
+	b := filepath.Join(dir, "copy of a.mov")
+	for _, p := range []string{a, b} {
+		if err := os.WriteFile(p, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, mode := range []string{contentCacheFast, contentCacheFull} {
+		ha, err := contentHash(a, mode)
+		if err != nil {
+			t.Fatalf("contentHash(%s) failed: %v", mode, err)
+		}
+		if hb, _ := contentHash(b, mode); ha != hb {
+			t.Errorf("Expected identical content to hash the same in %s mode", mode)
+		}
+	}
+
+	// The fast hash does not read the middle of the file, the full one does.
+	data[hashChunk+10]++
+	if err := os.WriteFile(b, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fastA, _ := contentHash(a, contentCacheFast)
+	fastB, _ := contentHash(b, contentCacheFast)
+	fullA, _ := contentHash(a, contentCacheFull)
+	fullB, _ := contentHash(b, contentCacheFull)
+	if fastA != fastB || fullA == fullB {
+		t.Error("Expected only the full hash to see a change in the middle")
+	}
+
+	out := filepath.Join(dir, "a.mp4")
+	if err := os.WriteFile(out, []byte("converted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	spec := &jobSpec{conv: &converter.Config{MaxSize: 1920}}
+	key := cacheKey(fastA, spec, dir)
+	c := newContentCache(filepath.Join(dir, "cache.json"))
+	if _, ok := c.lookup(key); ok {
+		t.Error("Expected a miss before recording")
+	}
+	if err := c.record(key, a, out); err != nil {
+		t.Fatalf("record failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cache.json")); !os.IsNotExist(err) {
+		t.Error("Expected the cache to be saved later, not after every job")
+	}
+	c.flush()
+
+	reloaded := newContentCache(filepath.Join(dir, "cache.json"))
+	if got, ok := reloaded.lookup(cacheKey(fastB, spec, dir)); !ok || got != out {
+		t.Errorf("Expected the output to be reused for the same content, got %q, %v", got, ok)
+	}
+	other := &jobSpec{conv: &converter.Config{MaxSize: 720}}
+	if _, ok := reloaded.lookup(cacheKey(fastA, other, dir)); ok {
+		t.Error("Expected a miss with other settings")
+	}
+	if _, ok := reloaded.lookup(cacheKey(fastA, spec, filepath.Join(dir, "elsewhere"))); ok {
+		t.Error("Expected a miss for another destination folder")
+	}
+	// The output name depends on the template, date folders and preset.
+	for _, changed := range []*jobSpec{
+		{conv: spec.conv, template: "{stem}_small{ext}"},
+		{conv: spec.conv, dateFolders: "YYYY/MM"},
+		{conv: spec.conv, preset: "chat"},
+	} {
+		if _, ok := reloaded.lookup(cacheKey(fastA, changed, dir)); ok {
+			t.Errorf("Expected a second conversion after changing the output name to %+v", *changed)
+		}
+	}
+	if _, ok := reloaded.lookup(cacheKey(fastA, &jobSpec{conv: spec.conv, template: defaultOutputTemplate}, dir)); !ok {
+		t.Error("Expected the default template to match an empty one")
+	}
+
+	if err := os.WriteFile(out, []byte("edited afterwards"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reloaded.lookup(key); ok {
+		t.Error("Expected a miss once the output was modified")
+	}
+}
+
+func TestBatchSummary(t *testing.T) {
+	app := NewApp()
+	batch := app.newBatch(4)
+	for _, id := range []string{"a", "b", "c", "d"} {
+		app.jobs[id] = &JobStatus{ID: id, Status: "queued", Batch: batch}
+		app.jobOrder = append(app.jobOrder, id)
+	}
+
+	app.countBytes("a", 1000, 200)
+	app.reportJob("a", "a.mp4", 100, "done", "", "")
+	app.reportJob("b", "", 100, "error", "Unsupported format", "")
+	app.CancelJob("c")
+	// Removing a finished job from the list does not cancel it.
+	app.CancelJob("a")
+
+	s, err := app.GetBatch(batch)
+	if err != nil {
+		t.Fatalf("GetBatch failed: %v", err)
+	}
+	if s.Finished || s.Done != 1 || s.Failed != 1 || s.Canceled != 1 || s.InputBytes != 1000 || s.OutputBytes != 200 {
+		t.Errorf("Unexpected running summary: %+v", s)
+	}
+
+	// A job that never reported, e.g. stopped by a shutdown, counts as
+	// canceled once the batch ends.
+	s = app.finishBatch(batch)
+	if !s.Finished || s.Jobs != 4 || s.Done != 1 || s.Failed != 1 || s.Skipped != 0 || s.Canceled != 2 {
+		t.Errorf("Unexpected final summary: %+v", s)
+	}
+
+	app.ClearCompletedJobs()
+	if _, err := app.GetBatch(batch); err != nil {
+		t.Error("Expected the batch to be kept while its jobs are listed")
+	}
+	delete(app.jobs, "b")
+	delete(app.jobs, "d")
+	app.ClearCompletedJobs()
+	if _, err := app.GetBatch(batch); err == nil {
+		t.Error("Expected the batch to be forgotten once its jobs were cleared")
+	}
+	if _, err := app.GetBatch("unknown"); err == nil {
+		t.Error("Expected an unknown batch to be rejected")
+	}
+}
+
+func TestFolderScan(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "Trip")
+	for _, f := range []string{"a.mov", "b.HEIC", "notes.txt", ".hidden.mov", "sub/c.mov", "sub/deep/d.heic", "skip/e.mov"} {
+		path := filepath.Join(root, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "empty.mov"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A link back to the folder itself must not be scanned twice.
+	if err := os.Symlink(root, filepath.Join(root, "loop")); err != nil {
+		t.Skipf("Symlinks not supported: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(root, "sub", "c.mov"), filepath.Join(root, "linked.mov")); err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(opts scanOptions) []string {
+		files, err := opts.scan(root)
+		if err != nil {
+			t.Fatalf("scan failed: %v", err)
+		}
+		var got []string
+		for _, f := range files {
+			rel, _ := filepath.Rel(root, f.path)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		return got
+	}
+	globs := func(g ...string) []folderGlob {
+		compiled, err := compileGlobs(g)
+		if err != nil {
+			t.Fatalf("compileGlobs failed: %v", err)
+		}
+		return compiled
+	}
+
+	cases := []struct {
+		name string
+		opts scanOptions
+		want []string
+	}{
+		{"defaults", scanOptions{skipHidden: true}, []string{"a.mov", "b.HEIC", "skip/e.mov", "sub/c.mov", "sub/deep/d.heic"}},
+		{"depth", scanOptions{skipHidden: true, maxDepth: 2}, []string{"a.mov", "b.HEIC", "skip/e.mov", "sub/c.mov"}},
+		{"exclude name", scanOptions{skipHidden: true, exclude: globs("skip")}, []string{"a.mov", "b.HEIC", "sub/c.mov", "sub/deep/d.heic"}},
+		{"exclude path", scanOptions{skipHidden: true, exclude: globs("sub/**")}, []string{"a.mov", "b.HEIC", "skip/e.mov"}},
+		{"include", scanOptions{skipHidden: true, include: globs("*.MOV")}, []string{"a.mov", "skip/e.mov", "sub/c.mov"}},
+		{"hidden", scanOptions{include: globs("*.mov")}, []string{".hidden.mov", "a.mov", "skip/e.mov", "sub/c.mov"}},
+		{"symlinks", scanOptions{skipHidden: true, followSymlinks: true, maxDepth: 1}, []string{"a.mov", "b.HEIC", "linked.mov"}},
+		{"symlink loop", scanOptions{skipHidden: true, followSymlinks: true, include: globs("a.mov")}, []string{"a.mov"}},
+	}
+	for _, c := range cases {
+		if got := names(c.opts); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+	}
+
+	if _, err := compileGlobs([]string{"["}); err != nil {
+		t.Errorf("Expected glob metacharacters to be matched literally, got %v", err)
+	}
+
+	viper.Set("folderSkipHidden", true)
+	viper.Set("mirrorFolders", true)
+	defer viper.Reset()
+	files, folders := splitFolders([]ConvertRequest{{File: filepath.Join(root, "a.mov")}, {File: root, Options: JobOptions{Preset: "small"}}})
+	if len(files) != 1 || len(folders) != 1 {
+		t.Fatalf("Expected one file and one folder, got %v and %v", files, folders)
+	}
+	scan, requests := expandFolders(folders)
+	if scan.Files != 5 || scan.Bytes != 20 || len(requests) != 5 || scan.Error != "" {
+		t.Fatalf("Unexpected scan %+v with %d requests", scan, len(requests))
+	}
+	if r := requests[3]; r.Subdir != filepath.Join("Trip", "sub") || r.Options.Preset != "small" {
+		t.Errorf("Expected the folder structure and options to be kept, got %+v", r)
+	}
+
+	app := NewApp()
+	app.folderScans[scan.ID] = requests
+	if err := app.ConfirmFolderScan(scan.ID, false); err != nil {
+		t.Errorf("ConfirmFolderScan failed: %v", err)
+	}
+	if err := app.ConfirmFolderScan(scan.ID, true); err == nil {
+		t.Error("Expected a declined scan to be forgotten")
+	}
+
+	// Unanswered scans are dropped after the timeout or on close.
+	app.folderScans[scan.ID] = requests
+	app.expireFolderScan(scan.ID)
+	if err := app.ConfirmFolderScan(scan.ID, true); err == nil {
+		t.Error("Expected an expired scan to be forgotten")
+	}
+	app.folderScans[scan.ID] = requests
+	app.setClosing()
+	if len(app.folderScans) != 0 {
+		t.Errorf("Expected closing to drop pending scans, got %d", len(app.folderScans))
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	base := converter.Config{FfmpegBinary: "ffmpeg", MaxSize: 1920, VideoQuality: "high"}
+	fingerprint := func(change func(c *converter.Config)) string {
+		conv := base
+		change(&conv)
+		return (&jobSpec{conv: &conv}).fingerprint()
+	}
+	want := fingerprint(func(c *converter.Config) {})
+
+	runOnly := fingerprint(func(c *converter.Config) {
+		c.FfmpegBinary = `C:\tools\ffmpeg.exe`
+		c.Priority = converter.PriorityLow
+		c.FfmpegThreads = 4
+		c.MagickMemoryLimit = "1GiB"
+		c.StallTimeout = time.Minute
+		c.TimeoutFactor = 3
+	})
+	if runOnly != want {
+		t.Error("Expected settings that do not change the output to keep the fingerprint")
+	}
+	if fingerprint(func(c *converter.Config) { c.MaxSize = 720 }) == want {
+		t.Error("Expected the output size to change the fingerprint")
+	}
+	if fingerprint(func(c *converter.Config) { c.AudioBitrate = "96k" }) == want {
+		t.Error("Expected the audio bitrate to change the fingerprint")
 	}
 }
@@ -7,6 +7,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/minjejeon/convert4share/converter"
 	"github.com/minjejeon/convert4share/windows"
@@ -184,7 +185,14 @@ func (a *App) GetThumbnail(path string) (string, error) {
 		FfmpegBinary: viper.GetString("ffmpegBinary"),
 	}
 
+	release, err := a.workers.acquire(a.ctx, poolThumbnail, 1)
+	if err != nil {
+		return "", err
+	}
 	data, err := convConfig.GenerateThumbnail(a.ctx, path)
+	release()
+	// The freed share may let a queued job start.
+	a.sched.wake()
 	if err != nil {
 		logger.Error("Failed to generate thumbnail", "path", path, "ffmpeg", convConfig.FfmpegBinary, "magick", convConfig.MagickBinary, "error", err)
 		return "", fmt.Errorf("failed to generate thumbnail: %w", err)
@@ -193,3 +201,37 @@ func (a *App) GetThumbnail(path string) (string, error) {
 	base64Str := base64.StdEncoding.EncodeToString(data)
 	return "data:image/jpeg;base64," + base64Str, nil
 }
+
+// GetFrameThumbnails returns a JPEG data URI for each timestamp (in seconds)
+// of a video, for building a trim range picker. Like thumbnails, each frame
+// takes a share of the thumbnail pool while it is extracted.
+func (a *App) GetFrameThumbnails(path string, timestamps []float64) ([]string, error) {
+	convConfig := newConverterConfig()
+
+	frames := make([]string, 0, len(timestamps))
+	for _, ts := range timestamps {
+		at := time.Duration(ts * float64(time.Second))
+		release, err := a.workers.acquire(a.ctx, poolThumbnail, 1)
+		if err != nil {
+			return nil, err
+		}
+		data, err := convConfig.GenerateFrame(a.ctx, path, at, 160)
+		release()
+		a.sched.wake()
+		if err != nil {
+			logger.Error("Failed to extract frame", "path", path, "at", at, "error", err)
+			return nil, fmt.Errorf("failed to extract frame at %s: %w", at, err)
+		}
+		frames = append(frames, "data:image/jpeg;base64,"+base64.StdEncoding.EncodeToString(data))
+	}
+	return frames, nil
+}
+
+// GetMediaDuration returns the duration of a video in seconds.
+func (a *App) GetMediaDuration(path string) (float64, error) {
+	info, err := newConverterConfig().ProbeMedia(a.ctx, path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Duration.Seconds(), nil
+}
@@ -0,0 +1,122 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// EBU R128 targets used for loudness normalization.
+const (
+	loudnessTargetI   = "-16"
+	loudnessTargetTP  = "-1.5"
+	loudnessTargetLRA = "11"
+)
+
+// loudnessStats holds the measurements printed by the first loudnorm pass.
+type loudnessStats struct {
+	InputI       string `json:"input_i"`
+	InputTP      string `json:"input_tp"`
+	InputLRA     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+// audioArgs returns the output options for the audio stream. info and
+// loudness may be nil when the source was not probed or measured.
+func (c *Config) audioArgs(info *MediaInfo, loudness *loudnessStats) []string {
+	if c.AudioMute {
+		return []string{"-an"}
+	}
+
+	if c.canCopyAudio(info) {
+		log.Println("Source audio is AAC and needs no processing, copying it through.")
+		return []string{"-c:a", "copy"}
+	}
+
+	args := []string{"-c:a", "aac"}
+	if c.AudioBitrate != "" {
+		args = append(args, "-b:a", c.AudioBitrate)
+	}
+	if c.AudioChannels > 0 {
+		args = append(args, "-ac", strconv.Itoa(c.AudioChannels))
+	}
+	if c.AudioNormalize {
+		// loudnorm resamples internally to 192 kHz, so pin the output rate.
+		args = append(args, "-af", loudnormFilter(loudness), "-ar", "48000")
+	}
+	return args
+}
+
+// canCopyAudio reports whether the source audio can be passed through: it
+// must be AAC and need no normalization, downmix or bitrate change.
+func (c *Config) canCopyAudio(info *MediaInfo) bool {
+	if !c.AudioCopy || c.AudioNormalize || c.AudioBitrate != "" || info == nil || info.AudioCodec != "aac" {
+		return false
+	}
+	return c.AudioChannels == 0 || c.AudioChannels == info.AudioChannels
+}
+
+// loudnormFilter builds the loudnorm filter. With measurements from a first
+// pass it performs linear two-pass normalization, otherwise it falls back to
+// the single-pass dynamic mode.
+func loudnormFilter(stats *loudnessStats) string {
+	filter := fmt.Sprintf("loudnorm=I=%s:TP=%s:LRA=%s", loudnessTargetI, loudnessTargetTP, loudnessTargetLRA)
+	if stats == nil {
+		return filter
+	}
+	return fmt.Sprintf("%s:measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true",
+		filter, stats.InputI, stats.InputTP, stats.InputLRA, stats.InputThresh, stats.TargetOffset)
+}
+
+// measureLoudness runs the analysis pass of two-pass loudness normalization.
+// The pass reads the whole source, so like the conversion it is killed when
+// its progress stalls or it runs past the time limit.
+func (c *Config) measureLoudness(ctx context.Context, orig string, duration time.Duration) (*loudnessStats, error) {
+	args := []string{"-hide_banner"}
+	args = append(args, c.inputArgs(orig)...)
+	args = append(args, c.trimArgs()...)
+	args = append(args,
+		"-vn",
+		"-af", loudnormFilter(nil)+":print_format=json",
+		"-f", "null",
+		"-",
+	)
+	dog := newWatchdog(orig, controlFrom(ctx), c.StallTimeout, c.TimeLimit(c.clipDuration(duration)))
+	stderr := &progressWriter{dog: dog}
+	err := dog.watch(ctx, func(ctx context.Context) error {
+		cmd := prepareCommandContext(ctx, c.Priority, c.FfmpegBinary, args...)
+		cmd.Stdin = nil
+		cmd.Stderr = stderr
+		return runControlled(ctx, cmd)
+	})
+	stderr.endLine()
+	if err != nil {
+		return nil, fmt.Errorf("loudness analysis failed: %w. Log: %s", err, stderr.out.String())
+	}
+
+	return parseLoudnessStats(stderr.out.Bytes())
+}
+
+// parseLoudnessStats extracts the JSON block loudnorm prints at the end of
+// the analysis pass.
+func parseLoudnessStats(output []byte) (*loudnessStats, error) {
+	start := bytes.LastIndexByte(output, '{')
+	end := bytes.LastIndexByte(output, '}')
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("loudness analysis produced no measurements")
+	}
+
+	var stats loudnessStats
+	if err := json.Unmarshal(output[start:end+1], &stats); err != nil {
+		return nil, fmt.Errorf("could not parse loudness measurements: %w", err)
+	}
+	if stats.InputI == "" || stats.InputI == "-inf" {
+		return nil, fmt.Errorf("source audio is silent")
+	}
+	return &stats, nil
+}
@@ -0,0 +1,140 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Sources of a capture date, in the order CaptureDate tries them.
+const (
+	DateFromMetadata = "metadata"
+	DateFromFilename = "filename"
+	DateFromModTime  = "mtime"
+)
+
+// filenameDatePatterns recognize the dates cameras and apps put in file
+// names. The captured groups are joined with a space and parsed with layout.
+var filenameDatePatterns = []struct {
+	re     *regexp.Regexp
+	layout string
+	utc    bool
+}{
+	// Pixel phones name files in UTC: PXL_20240101_123456789.jpg
+	{regexp.MustCompile(`^PXL_(\d{8}_\d{6})`), "20060102_150405", true},
+	// Android, Samsung and Huawei: IMG_20240101_123456.jpg, VID_20240101_123456.mp4, 20240101_123456.jpg
+	{regexp.MustCompile(`^(?:[A-Za-z]+_)?(\d{8}_\d{6})`), "20060102_150405", false},
+	// WhatsApp: IMG-20240101-WA0001.jpg
+	{regexp.MustCompile(`^(?:IMG|VID)-(\d{8})-WA`), "20060102", false},
+	// Screenshots and exports: "Screenshot 2024-01-01 at 12.00.00.png"
+	{regexp.MustCompile(`(\d{4}-\d{2}-\d{2}) (?:at )?(\d{2}\.\d{2}\.\d{2})`), "2006-01-02 15.04.05", false},
+	{regexp.MustCompile(`(?:^|\D)(\d{4}-\d{2}-\d{2})(?:\D|$)`), "2006-01-02", false},
+}
+
+// IsVideoFile reports whether the path has a video extension handled by ffmpeg.
+func IsVideoFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mov", ".mp4", ".m4v", ".mkv", ".avi":
+		return true
+	}
+	return false
+}
+
+// CaptureTime returns the capture date embedded in the file: the QuickTime
+// creation date of a video or the EXIF DateTimeOriginal of an image. It
+// returns an error when the file carries no capture date.
+func (c *Config) CaptureTime(ctx context.Context, path string) (time.Time, error) {
+	if IsVideoFile(path) {
+		info, err := c.ProbeMedia(ctx, path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if t, ok := videoCaptureTime(info); ok {
+			return t, nil
+		}
+		return time.Time{}, fmt.Errorf("no creation time in %s", path)
+	}
+
+	cmd := prepareCommandContext(ctx, c.Priority, c.MagickBinary, "identify", "-format", "%[EXIF:DateTimeOriginal]|%[EXIF:OffsetTimeOriginal]", path+"[0]")
+	cmd.Stdin = nil
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return time.Time{}, fmt.Errorf("identify failed: %w. Output: %s", err, stderr.String())
+	}
+	return parseExifDateTime(stdout.String())
+}
+
+// videoCaptureTime picks the capture date of a probed video. The QuickTime
+// key carries the local offset of the capture, while creation_time may have
+// been rewritten by editing software. creation_time is stored in UTC and is
+// returned in local time, so that dates derived from it fall on the day the
+// video was taken here.
+func videoCaptureTime(info *MediaInfo) (time.Time, bool) {
+	if t, err := time.Parse("2006-01-02T15:04:05-0700", info.QuickTimeCreationDate); err == nil {
+		return t, true
+	}
+	if !info.CreationTime.IsZero() {
+		return info.CreationTime.Local(), true
+	}
+	return time.Time{}, false
+}
+
+// parseExifDateTime parses "DateTimeOriginal|OffsetTimeOriginal" as printed
+// by identify. Without an offset the time is taken as local time.
+func parseExifDateTime(s string) (time.Time, error) {
+	date, offset, _ := strings.Cut(strings.TrimSpace(s), "|")
+	if date == "" {
+		return time.Time{}, fmt.Errorf("no EXIF capture date")
+	}
+	if offset != "" {
+		if t, err := time.Parse("2006:01:02 15:04:05-07:00", date+offset); err == nil {
+			return t, nil
+		}
+	}
+	return time.ParseInLocation("2006:01:02 15:04:05", date, time.Local)
+}
+
+// CaptureDate resolves when a photo or video was taken: from the embedded
+// metadata, then from a date in the file name, then from the file's
+// modification time. It also returns which of these the date came from.
+func (c *Config) CaptureDate(ctx context.Context, path string) (time.Time, string, error) {
+	if t, err := c.CaptureTime(ctx, path); err == nil {
+		return t, DateFromMetadata, nil
+	}
+	if t, ok := parseFilenameDate(filepath.Base(path), time.Now()); ok {
+		return t, DateFromFilename, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	return info.ModTime(), DateFromModTime, nil
+}
+
+// parseFilenameDate extracts a capture date from a file name. Dates before
+// 1990 or after now are rejected, as they are more likely counters or IDs.
+func parseFilenameDate(name string, now time.Time) (time.Time, bool) {
+	for _, p := range filenameDatePatterns {
+		m := p.re.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		loc := time.Local
+		if p.utc {
+			loc = time.UTC
+		}
+		t, err := time.ParseInLocation(p.layout, strings.Join(m[1:], " "), loc)
+		if err != nil || t.Year() < 1990 || t.After(now.Add(24*time.Hour)) {
+			continue
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
@@ -5,12 +5,33 @@ package converter
 import (
 	"context"
 	"os/exec"
+	"runtime"
 )
 
 func prepareCommand(name string, args ...string) *exec.Cmd {
 	return exec.Command(name, args...)
 }
 
-func prepareCommandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+// prepareCommandContext creates a command that is killed when ctx is done.
+// With PriorityLow it runs under nice and, on Linux, with the lowest
+// best-effort I/O priority; the wrappers exec the command, so its process
+// ID stays the same.
+func prepareCommandContext(ctx context.Context, priority, name string, args ...string) *exec.Cmd {
+	if priority == PriorityLow {
+		name, args = wrapCommand("nice", []string{"-n", "10"}, name, args)
+		if runtime.GOOS == "linux" {
+			name, args = wrapCommand("ionice", []string{"-c", "2", "-n", "7"}, name, args)
+		}
+	}
 	return exec.CommandContext(ctx, name, args...)
 }
+
+// wrapCommand runs name through wrapper, if the wrapper is installed.
+func wrapCommand(wrapper string, wrapperArgs []string, name string, args []string) (string, []string) {
+	path, err := exec.LookPath(wrapper)
+	if err != nil {
+		return name, args
+	}
+	wrapped := append(append(wrapperArgs, name), args...)
+	return path, wrapped
+}
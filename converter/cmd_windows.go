@@ -8,6 +8,10 @@ import (
 	"syscall"
 )
 
+// belowNormalPriorityClass is the BELOW_NORMAL_PRIORITY_CLASS process
+// creation flag.
+const belowNormalPriorityClass = 0x00004000
+
 func prepareCommand(name string, args ...string) *exec.Cmd {
 	cmd := exec.Command(name, args...)
 	cmd.SysProcAttr = &syscall.SysProcAttr{
@@ -16,10 +20,15 @@ func prepareCommand(name string, args ...string) *exec.Cmd {
 	return cmd
 }
 
-func prepareCommandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+// prepareCommandContext creates a hidden command that is killed when ctx is
+// done. With PriorityLow it runs in the below normal priority class.
+func prepareCommandContext(ctx context.Context, priority, name string, args ...string) *exec.Cmd {
 	cmd := exec.CommandContext(ctx, name, args...)
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		HideWindow: true,
 	}
+	if priority == PriorityLow {
+		cmd.SysProcAttr.CreationFlags |= belowNormalPriorityClass
+	}
 	return cmd
 }
@@ -0,0 +1,133 @@
+package converter
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// Control suspends and resumes the child processes of a conversion and keeps
+// track of how long they were suspended, so that speed and time estimates
+// only count the time spent working. The zero value is ready to use.
+type Control struct {
+	mu        sync.Mutex
+	proc      *os.Process
+	suspended bool
+	since     time.Time
+	total     time.Duration
+}
+
+type controlKey struct{}
+
+// WithControl returns a context that lets c suspend the processes started
+// with it.
+func WithControl(ctx context.Context, c *Control) context.Context {
+	return context.WithValue(ctx, controlKey{}, c)
+}
+
+// controlFrom returns the Control of ctx, or nil.
+func controlFrom(ctx context.Context) *Control {
+	c, _ := ctx.Value(controlKey{}).(*Control)
+	return c
+}
+
+// Suspend stops the running process. A process started while suspended is
+// stopped as soon as it starts.
+func (c *Control) Suspend() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.suspended {
+		return nil
+	}
+	if c.proc != nil {
+		if err := suspendProcess(c.proc); err != nil {
+			return err
+		}
+	}
+	c.suspended = true
+	c.since = time.Now()
+	return nil
+}
+
+// Resume continues the suspended process.
+func (c *Control) Resume() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.suspended {
+		return nil
+	}
+	if c.proc != nil {
+		if err := resumeProcess(c.proc); err != nil {
+			return err
+		}
+	}
+	c.suspended = false
+	c.total += time.Since(c.since)
+	return nil
+}
+
+// Suspended reports whether the conversion is suspended.
+func (c *Control) Suspended() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.suspended
+}
+
+// SuspendedFor returns how long the conversion has been suspended in total.
+func (c *Control) SuspendedFor() time.Duration {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	total := c.total
+	if c.suspended {
+		total += time.Since(c.since)
+	}
+	return total
+}
+
+// attach makes p the process that Suspend and Resume act on.
+func (c *Control) attach(p *os.Process) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.proc = p
+	if c.suspended {
+		if err := suspendProcess(p); err != nil {
+			c.suspended = false
+			c.total += time.Since(c.since)
+		}
+	}
+}
+
+func (c *Control) detach() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.proc = nil
+}
+
+// runControlled runs cmd like cmd.Run, letting the Control of ctx suspend it.
+func runControlled(ctx context.Context, cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	ctl := controlFrom(ctx)
+	ctl.attach(cmd.Process)
+	defer ctl.detach()
+	return cmd.Wait()
+}
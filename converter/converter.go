@@ -8,24 +8,57 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 )
 
 type Job struct{ Orig, Dest string }
 
 type Config struct {
-	MagickBinary        string
-	FfmpegBinary        string
-	MaxSize             int
-	HardwareAccelerator string
-	FfmpegCustomArgs    string
-	VideoQuality        string // "high", "medium", "low"
+	MagickBinary          string
+	FfmpegBinary          string
+	MaxSize               int
+	HardwareAccelerator   string
+	FfmpegInputArgs       string                // custom options placed before -i
+	FfmpegCustomArgs      string                // custom options placed before the output path
+	FfmpegAcceleratorArgs map[string]CustomArgs // per-accelerator replacements for the two above
+	VideoQuality          string                // "high", "medium", "low"
+	VideoCodec            string                // "h264" (default) or "hevc"
+	AudioBitrate          string                // e.g. "128k"; empty keeps the encoder default
+	AudioChannels         int                   // 0 keeps the source layout, 1 mono, 2 stereo
+	AudioNormalize        bool                  // two-pass EBU R128 loudness normalization
+	AudioCopy             bool                  // pass AAC audio through when no processing is needed
+	AudioMute             bool                  // strip the audio stream
+	TrimStart             time.Duration         // clip start; 0 starts at the beginning
+	TrimEnd               time.Duration         // clip end; 0 runs to the end of the input
+	Rotate                int                   // clockwise degrees: 0, 90, 180 or 270
+	FlipH                 bool                  // mirror horizontally
+	FlipV                 bool                  // mirror vertically
+	Crop                  CropRect              // applied before flip and rotate; zero keeps the full frame
+	VideoMetadata         string                // "keep" (default), "dates" or "strip"
+	ImageMetadata         string                // "keep" (default), "no-gps", "minimal" or "strip"
+	Priority              string                // PriorityNormal (default) or PriorityLow
+	FfmpegThreads         int                   // -threads for encoding; 0 lets ffmpeg decide
+	MagickThreads         int                   // MAGICK_THREAD_LIMIT; 0 keeps the ImageMagick default
+	MagickMemoryLimit     string                // -limit memory, e.g. "1GiB"; empty keeps the default
+	MagickDiskLimit       string                // -limit disk, e.g. "4GiB"; empty keeps the default
+	StallTimeout          time.Duration         // kill ffmpeg after this long without progress; 0 disables
+	TimeoutFactor         float64               // kill ffmpeg after this multiple of the media duration; 0 disables
 }
 
-type ProgressCallback func(progress int, speed string)
+// Process priorities of the converter child processes.
+const (
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// ProgressCallback receives the progress in percent, the encoding speed and
+// the estimated time left, which is 0 while unknown.
+type ProgressCallback func(progress int, speed string, eta time.Duration)
 
 var (
 	durationRegex = regexp.MustCompile(`Duration: (\d+):(\d{2}):(\d{2})\.(\d+)`)
 	timeRegex     = regexp.MustCompile(`time=(\d+):(\d{2}):(\d{2})\.(\d+)`)
+	frameRegex    = regexp.MustCompile(`frame=\s*(\d+)`)
 	speedRegex    = regexp.MustCompile(`speed=\s*([\d\.]+)x`)
 )
 
@@ -48,7 +81,7 @@ func (c *Config) GenerateThumbnail(ctx context.Context, inputFile string) ([]byt
 			"-c:v", "mjpeg",
 			"pipe:1",
 		}
-		cmd = prepareCommandContext(ctx, c.FfmpegBinary, args...)
+		cmd = prepareCommandContext(ctx, c.Priority, c.FfmpegBinary, args...)
 	} else {
 		// Note: For HEIC, magick handles it if delegates are present.
 		// We use input[0] to get the first frame/page.
@@ -58,7 +91,7 @@ func (c *Config) GenerateThumbnail(ctx context.Context, inputFile string) ([]byt
 			"-quality", "80",
 			"jpeg:-",
 		}
-		cmd = prepareCommandContext(ctx, c.MagickBinary, args...)
+		cmd = prepareCommandContext(ctx, c.Priority, c.MagickBinary, args...)
 	}
 
 	// Ensure standard input is closed
@@ -76,6 +109,37 @@ func (c *Config) GenerateThumbnail(ctx context.Context, inputFile string) ([]byt
 	return stdout.Bytes(), nil
 }
 
+// GenerateFrame extracts a single JPEG frame at the given position of a video,
+// scaled to the given width.
+func (c *Config) GenerateFrame(ctx context.Context, inputFile string, at time.Duration, width int) ([]byte, error) {
+	args := []string{
+		"-hide_banner",
+		"-loglevel", "error",
+		"-ss", formatSeconds(at),
+		"-i", inputFile,
+		"-vframes", "1",
+		"-vf", fmt.Sprintf("scale=%d:-1", width),
+		"-f", "image2",
+		"-c:v", "mjpeg",
+		"pipe:1",
+	}
+	cmd := prepareCommandContext(ctx, c.Priority, c.FfmpegBinary, args...)
+	cmd.Stdin = nil
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("frame extraction failed: %w. Log: %s", err, stderr.String())
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("no frame at %s", at)
+	}
+
+	return stdout.Bytes(), nil
+}
+
 func scanCR(data []byte, atEOF bool) (advance int, token []byte, err error) {
 	if atEOF && len(data) == 0 {
 		return 0, nil, nil
@@ -1,8 +1,16 @@
 package converter
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 )
 
 // BenchmarkFfmpegOutputParsing benchmarks the regex matching used in Ffmpeg parsing.
@@ -99,6 +107,158 @@ func TestBuildFfmpegArgs_Nvidia(t *testing.T) {
 	}
 }
 
+func TestBuildFfmpegArgs_Hevc(t *testing.T) {
+	encoders := map[string]string{"amd": "hevc_amf", "nvidia": "hevc_nvenc", "none": "libx265"}
+
+	for accel, encoder := range encoders {
+		c := Config{MaxSize: 1920, HardwareAccelerator: accel, VideoQuality: "high", VideoCodec: "hevc"}
+		joined := strings.Join(c.BuildFfmpegArgs("input.mov", "output.mp4"), " ")
+
+		if !strings.Contains(joined, "-c:v "+encoder) {
+			t.Errorf("Expected encoder %s for %s, got %q", encoder, accel, joined)
+		}
+		if !strings.Contains(joined, "-tag:v hvc1") {
+			t.Errorf("Expected hvc1 tag for %s, got %q", accel, joined)
+		}
+		if strings.Contains(joined, "-bf ") {
+			t.Errorf("Did not expect -bf for %s HEVC, got %q", accel, joined)
+		}
+	}
+}
+
+func TestBuildFfmpegArgs_Trim(t *testing.T) {
+	for _, accel := range []string{"amd", "nvidia", "none"} {
+		c := Config{MaxSize: 1920, HardwareAccelerator: accel, TrimStart: 12500 * time.Millisecond, TrimEnd: 20 * time.Second}
+		args := c.BuildFfmpegArgs("input.mov", "output.mp4")
+
+		ssIdx, inputIdx, tIdx := -1, -1, -1
+		for i, a := range args {
+			switch a {
+			case "-ss":
+				ssIdx = i
+			case "-i":
+				inputIdx = i
+			case "-t":
+				tIdx = i
+			}
+		}
+
+		if ssIdx < 0 || args[ssIdx+1] != "12.500" {
+			t.Errorf("Expected -ss 12.500 for %s, got %v", accel, args)
+		}
+		if inputIdx < 0 || ssIdx > inputIdx {
+			t.Errorf("Expected -ss before -i for accurate input seeking on %s, got %v", accel, args)
+		}
+		if tIdx < inputIdx || args[tIdx+1] != "7.500" {
+			t.Errorf("Expected output -t 7.500 for %s, got %v", accel, args)
+		}
+	}
+
+	c := Config{HardwareAccelerator: "none"}
+	joined := strings.Join(c.BuildFfmpegArgs("input.mov", "output.mp4"), " ")
+	if strings.Contains(joined, "-ss") || strings.Contains(joined, "-t ") {
+		t.Errorf("Did not expect trim arguments without a range, got %q", joined)
+	}
+}
+
+func TestTrimDuration(t *testing.T) {
+	full := 5 * time.Minute
+	tests := []struct {
+		start, end, want time.Duration
+	}{
+		{0, 0, full},
+		{10 * time.Second, 0, full - 10*time.Second},
+		{10 * time.Second, 20 * time.Second, 10 * time.Second},
+		{0, 10 * time.Minute, full},
+	}
+	for _, tt := range tests {
+		c := Config{TrimStart: tt.start, TrimEnd: tt.end}
+		if got := c.clipDuration(full); got != tt.want {
+			t.Errorf("clipDuration(%s..%s) = %s; want %s", tt.start, tt.end, got, tt.want)
+		}
+	}
+
+	if err := (&Config{TrimStart: 20 * time.Second, TrimEnd: 10 * time.Second}).validateTrim(full); err == nil {
+		t.Error("Expected error for end before start")
+	}
+	if err := (&Config{TrimStart: 6 * time.Minute}).validateTrim(full); err == nil {
+		t.Error("Expected error for start beyond the end")
+	}
+}
+
+func TestParseTimecode(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"12.5", 12500 * time.Millisecond, false},
+		{"01:02.5", 62500 * time.Millisecond, false},
+		{"01:00:00", time.Hour, false},
+		{"", 0, false},
+		{"abc", 0, true},
+		{"1:2:3:4", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTimecode(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseTimecode(%q) = %s, %v; want %s, err=%v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestBuildFfmpegArgs_Transform(t *testing.T) {
+	crop := CropRect{X: 420, Y: 0, Width: 1080, Height: 1080}
+	expected := map[string]string{
+		"amd":    "crop=1080:1080:420:0,hflip,transpose=clock,vpp_amf=",
+		"nvidia": "crop=1080:1080:420:0,hflip,transpose=clock,scale=",
+		"none":   "crop=1080:1080:420:0,hflip,transpose=clock,scale=",
+	}
+
+	for accel, prefix := range expected {
+		c := Config{MaxSize: 1920, HardwareAccelerator: accel, Rotate: 90, FlipH: true, Crop: crop}
+		args := c.buildFfmpegArgs("input.mov", "output.mp4", &MediaInfo{Rotation: 90}, nil)
+
+		var filter string
+		for i, a := range args {
+			if a == "-vf" && i+1 < len(args) {
+				filter = args[i+1]
+			}
+		}
+		if !strings.HasPrefix(filter, prefix) {
+			t.Errorf("Expected filter starting with %q for %s, got %q", prefix, accel, filter)
+		}
+		if !strings.Contains(strings.Join(args, " "), "-metadata:s:v:0 rotate=0") {
+			t.Errorf("Expected rotation tag to be cleared for %s, got %v", accel, args)
+		}
+	}
+
+	c := Config{Rotate: 180}
+	if got := strings.Join(c.transformFilters(), ","); got != "hflip,vflip" {
+		t.Errorf("Expected 180 degrees as hflip,vflip, got %q", got)
+	}
+	if err := (&Config{Rotate: 45}).validateTransform(); err == nil {
+		t.Error("Expected error for unsupported rotation")
+	}
+}
+
+func TestBuildMagickArgs(t *testing.T) {
+	c := Config{Rotate: 270, FlipV: true, Crop: CropRect{X: 10, Y: 20, Width: 300, Height: 200}}
+	got := strings.Join(c.BuildMagickArgs("in.heic", "out.jpg"), " ")
+	want := "in.heic -auto-orient -crop 300x200+10+20 +repage -flip -rotate 270 out.jpg"
+	if got != want {
+		t.Errorf("BuildMagickArgs() = %q; want %q", got, want)
+	}
+
+	rect, err := ParseCropRect("1080x1080+420+0")
+	if err != nil || rect != (CropRect{X: 420, Y: 0, Width: 1080, Height: 1080}) {
+		t.Errorf("ParseCropRect() = %+v, %v", rect, err)
+	}
+	if _, err := ParseCropRect("1080x1080"); err == nil {
+		t.Error("Expected error for geometry without offset")
+	}
+}
+
 func TestParseFractionToNanos(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -121,3 +281,514 @@ func TestParseFractionToNanos(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildFfmpegArgs_Audio(t *testing.T) {
+	aacStereo := &MediaInfo{AudioCodec: "aac", AudioChannels: 2}
+
+	tests := []struct {
+		name     string
+		config   Config
+		info     *MediaInfo
+		loudness *loudnessStats
+		want     []string
+		notWant  []string
+	}{
+		{
+			name:   "Default re-encodes to AAC",
+			config: Config{},
+			want:   []string{"-c:a aac"},
+		},
+		{
+			name:    "Mute strips audio",
+			config:  Config{AudioMute: true, AudioBitrate: "128k"},
+			want:    []string{"-an"},
+			notWant: []string{"-c:a", "-b:a"},
+		},
+		{
+			name:   "Bitrate and downmix",
+			config: Config{AudioBitrate: "96k", AudioChannels: 1},
+			want:   []string{"-c:a aac", "-b:a 96k", "-ac 1"},
+		},
+		{
+			name:    "Copy compatible AAC",
+			config:  Config{AudioCopy: true},
+			info:    aacStereo,
+			want:    []string{"-c:a copy"},
+			notWant: []string{"-b:a"},
+		},
+		{
+			name:   "Copy is skipped when downmix is needed",
+			config: Config{AudioCopy: true, AudioChannels: 1},
+			info:   aacStereo,
+			want:   []string{"-c:a aac", "-ac 1"},
+		},
+		{
+			name:   "Copy is skipped when a bitrate is set",
+			config: Config{AudioCopy: true, AudioBitrate: "96k"},
+			info:   aacStereo,
+			want:   []string{"-c:a aac", "-b:a 96k"},
+		},
+		{
+			name:   "Copy is skipped for other codecs",
+			config: Config{AudioCopy: true},
+			info:   &MediaInfo{AudioCodec: "pcm_s16le", AudioChannels: 2},
+			want:   []string{"-c:a aac"},
+		},
+		{
+			name:   "Single-pass loudnorm without measurements",
+			config: Config{AudioNormalize: true, AudioCopy: true},
+			info:   aacStereo,
+			want:   []string{"-af loudnorm=I=-16:TP=-1.5:LRA=11", "-ar 48000"},
+		},
+		{
+			name:   "Two-pass loudnorm with measurements",
+			config: Config{AudioNormalize: true},
+			loudness: &loudnessStats{
+				InputI: "-27.61", InputTP: "-4.47", InputLRA: "18.06", InputThresh: "-39.20", TargetOffset: "0.58",
+			},
+			want: []string{"-af loudnorm=I=-16:TP=-1.5:LRA=11:measured_I=-27.61:measured_TP=-4.47:measured_LRA=18.06:measured_thresh=-39.20:offset=0.58:linear=true"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.config.buildFfmpegArgs("input.mov", "output.mp4", tt.info, tt.loudness)
+			if args[len(args)-1] != "output.mp4" {
+				t.Errorf("Expected output path last, got %v", args)
+			}
+			joined := strings.Join(args, " ")
+			for _, w := range tt.want {
+				if !strings.Contains(joined, w) {
+					t.Errorf("Expected %q in %q", w, joined)
+				}
+			}
+			for _, nw := range tt.notWant {
+				if strings.Contains(joined, nw) {
+					t.Errorf("Did not expect %q in %q", nw, joined)
+				}
+			}
+		})
+	}
+}
+
+func TestParseLoudnessStats(t *testing.T) {
+	output := []byte(`[Parsed_loudnorm_0 @ 0x1] 
+{
+	"input_i" : "-27.61",
+	"input_tp" : "-4.47",
+	"input_lra" : "18.06",
+	"input_thresh" : "-39.20",
+	"output_i" : "-16.58",
+	"output_tp" : "-1.50",
+	"output_lra" : "14.78",
+	"output_thresh" : "-27.71",
+	"normalization_type" : "dynamic",
+	"target_offset" : "0.58"
+}
+`)
+	stats, err := parseLoudnessStats(output)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stats.InputI != "-27.61" || stats.InputThresh != "-39.20" || stats.TargetOffset != "0.58" {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	if _, err := parseLoudnessStats([]byte("no json here")); err == nil {
+		t.Error("Expected error for output without measurements")
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{`-preset slow -crf 23`, []string{"-preset", "slow", "-crf", "23"}},
+		{`-metadata title="My Clip" -x`, []string{"-metadata", "title=My Clip", "-x"}},
+		{`-metadata 'comment=it"s'`, []string{"-metadata", `comment=it"s`}},
+		{`-metadata title=a\ b`, []string{"-metadata", "title=a b"}},
+		{`-attach C:\fonts\a.ttf`, []string{"-attach", `C:\fonts\a.ttf`}},
+		{`  `, nil},
+		{`-x ""`, []string{"-x", ""}},
+	}
+	for _, tt := range tests {
+		got, err := SplitArgs(tt.input)
+		if err != nil {
+			t.Errorf("SplitArgs(%q) unexpected error: %v", tt.input, err)
+			continue
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+			t.Errorf("SplitArgs(%q) = %q; want %q", tt.input, got, tt.want)
+		}
+	}
+
+	if _, err := SplitArgs(`-metadata "title=open`); err == nil {
+		t.Error("Expected error for unterminated quote")
+	}
+}
+
+func TestBuildFfmpegArgs_CustomArgs(t *testing.T) {
+	c := Config{
+		HardwareAccelerator: "nvidia",
+		FfmpegInputArgs:     "-fflags +genpts",
+		FfmpegCustomArgs:    `-metadata title="Generic"`,
+		FfmpegAcceleratorArgs: map[string]CustomArgs{
+			"nvidia": {Output: `-rc vbr -metadata "title=NVIDIA clip"`},
+		},
+	}
+	args := c.BuildFfmpegArgs("input.mov", "output.mp4")
+	joined := strings.Join(args, "|")
+
+	if !strings.Contains(joined, "-fflags|+genpts|-i|input.mov") {
+		t.Errorf("Expected input args directly before -i, got %q", joined)
+	}
+	if !strings.Contains(joined, "-metadata|title=NVIDIA clip") || strings.Contains(joined, "Generic") {
+		t.Errorf("Expected accelerator output args to replace generic ones, got %q", joined)
+	}
+	if args[len(args)-1] != "output.mp4" {
+		t.Errorf("Expected output path last, got %q", joined)
+	}
+}
+
+func TestValidateCustomArgs(t *testing.T) {
+	c := Config{FfmpegCustomArgs: `-crf 23 -vf "eq=contrast=1.1"`}
+	err := c.ValidateCustomArgs()
+	var argsErr *CustomArgsError
+	if !errors.As(err, &argsErr) || len(argsErr.Conflicts) != 1 || !strings.HasPrefix(argsErr.Conflicts[0], "-vf") {
+		t.Errorf("Expected -vf conflict, got %v", err)
+	}
+
+	c = Config{
+		FfmpegInputArgs:       "-i other.mov",
+		FfmpegCustomArgs:      "-crf 23 out.mp4",
+		FfmpegAcceleratorArgs: map[string]CustomArgs{"amd": {Output: "-filter_complex x"}},
+	}
+	err = c.ValidateCustomArgs()
+	if !errors.As(err, &argsErr) || len(argsErr.Conflicts) != 3 {
+		t.Errorf("Expected -i, output path and -filter_complex conflicts, got %v", err)
+	}
+
+	c = Config{FfmpegCustomArgs: "-af volume=2", AudioNormalize: true}
+	if err := c.ValidateCustomArgs(); err == nil {
+		t.Error("Expected -af conflict with loudness normalization")
+	}
+
+	c = Config{FfmpegCustomArgs: "-preset slow -crf 23 -movflags +faststart"}
+	if err := c.ValidateCustomArgs(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// Options that take no value are not followed by one.
+	for _, args := range []string{"-shortest out.mp4", "-an extra.mp4", "-noautorotate extra.mp4"} {
+		c = Config{FfmpegCustomArgs: args}
+		err = c.ValidateCustomArgs()
+		if !errors.As(err, &argsErr) || len(argsErr.Conflicts) != 1 || !strings.Contains(argsErr.Conflicts[0], "output path") {
+			t.Errorf("Expected an output path conflict for %q, got %v", args, err)
+		}
+	}
+	c = Config{FfmpegCustomArgs: "-shortest -an -crf 23"}
+	if err := c.ValidateCustomArgs(); err != nil {
+		t.Errorf("Unexpected error for flags without values: %v", err)
+	}
+}
+
+func TestBuildFfmpegArgs_Metadata(t *testing.T) {
+	info := &MediaInfo{
+		CreationTime:          time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		QuickTimeCreationDate: "2024-01-01T21:00:00+0900",
+	}
+
+	tests := []struct {
+		policy  string
+		info    *MediaInfo
+		want    []string
+		notWant []string
+	}{
+		{
+			policy: "",
+			info:   info,
+			want:   []string{"-map_metadata|0", "-movflags|+use_metadata_tags"},
+		},
+		{
+			policy: MetadataKeep,
+			want:   []string{"-map_metadata|0", "-movflags|+use_metadata_tags"},
+		},
+		{
+			policy: MetadataDates,
+			info:   info,
+			want: []string{
+				"-map_metadata|-1",
+				"-metadata|creation_time=2024-01-01T12:00:00Z",
+				"-movflags|+use_metadata_tags|-metadata|com.apple.quicktime.creationdate=2024-01-01T21:00:00+0900",
+			},
+		},
+		{
+			policy:  MetadataDates,
+			want:    []string{"-map_metadata|-1"},
+			notWant: []string{"creation_time", "use_metadata_tags"},
+		},
+		{
+			policy:  MetadataStrip,
+			info:    info,
+			want:    []string{"-map_metadata|-1", "-map_chapters|-1"},
+			notWant: []string{"creation_time", "use_metadata_tags"},
+		},
+	}
+
+	for _, tt := range tests {
+		c := Config{HardwareAccelerator: "none", VideoMetadata: tt.policy}
+		args := c.buildFfmpegArgs("input.mov", "output.mp4", tt.info, nil)
+		joined := strings.Join(args, "|")
+		for _, w := range tt.want {
+			if !strings.Contains(joined, w) {
+				t.Errorf("policy %q: expected %q in %q", tt.policy, w, joined)
+			}
+		}
+		for _, nw := range tt.notWant {
+			if strings.Contains(joined, nw) {
+				t.Errorf("policy %q: did not expect %q in %q", tt.policy, nw, joined)
+			}
+		}
+		if args[len(args)-1] != "output.mp4" {
+			t.Errorf("policy %q: expected output path last, got %q", tt.policy, joined)
+		}
+	}
+
+	if err := (&Config{VideoMetadata: "some"}).validateVideoMetadata(); err == nil {
+		t.Error("Expected error for unknown policy")
+	}
+}
+
+func TestCheckVideoOutput(t *testing.T) {
+	in := &MediaInfo{Duration: 60 * time.Second, VideoCodec: "hevc", AudioCodec: "aac"}
+	good := &MediaInfo{Duration: 60*time.Second + 40*time.Millisecond, VideoCodec: "h264", AudioCodec: "aac"}
+
+	tests := []struct {
+		name    string
+		c       Config
+		in, out *MediaInfo
+		wantErr bool
+	}{
+		{"matching", Config{}, in, good, false},
+		{"truncated", Config{}, in, &MediaInfo{Duration: 31 * time.Second, VideoCodec: "h264", AudioCodec: "aac"}, true},
+		{"no video", Config{}, in, &MediaInfo{Duration: 60 * time.Second, AudioCodec: "aac"}, true},
+		{"lost audio", Config{}, in, &MediaInfo{Duration: 60 * time.Second, VideoCodec: "h264"}, true},
+		{"muted", Config{AudioMute: true}, in, &MediaInfo{Duration: 60 * time.Second, VideoCodec: "h264"}, false},
+		{"muted with audio", Config{AudioMute: true}, in, good, true},
+		{"trimmed", Config{TrimStart: 10 * time.Second, TrimEnd: 20 * time.Second}, in, &MediaInfo{Duration: 10 * time.Second, VideoCodec: "h264", AudioCodec: "aac"}, false},
+		{"unprobed input", Config{}, nil, good, false},
+	}
+	for _, tt := range tests {
+		reason := tt.c.checkVideoOutput(tt.in, tt.out)
+		if (reason != "") != tt.wantErr {
+			t.Errorf("%s: checkVideoOutput() = %q, wantErr %v", tt.name, reason, tt.wantErr)
+		}
+	}
+}
+
+func TestVerify_EmptyOutput(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "out.mp4")
+	if err := os.WriteFile(dest, nil, 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	c := Config{}
+	var verr *VerificationError
+	if err := c.Verify(context.Background(), "in.mov", dest); !errors.As(err, &verr) {
+		t.Errorf("Expected VerificationError for empty output, got %v", err)
+	}
+}
+
+func TestEstimateRemaining(t *testing.T) {
+	tests := []struct {
+		remaining time.Duration
+		speed     float64
+		want      time.Duration
+	}{
+		{60 * time.Second, 2, 30 * time.Second},
+		{60 * time.Second, 0.5, 2 * time.Minute},
+		{60 * time.Second, 0, 0},
+		{0, 2, 0},
+	}
+	for _, tt := range tests {
+		if got := estimateRemaining(tt.remaining, tt.speed); got != tt.want {
+			t.Errorf("estimateRemaining(%s, %v) = %s, want %s", tt.remaining, tt.speed, got, tt.want)
+		}
+	}
+	if got := formatSpeed(1.23456); got != "1.23x" {
+		t.Errorf("formatSpeed(1.23456) = %q, want 1.23x", got)
+	}
+	if got := formatSpeed(0); got != "" {
+		t.Errorf("formatSpeed(0) = %q, want empty", got)
+	}
+}
+
+func TestControl_SuspendedFor(t *testing.T) {
+	var c Control
+	if err := c.Suspend(); err != nil {
+		t.Fatalf("Suspend failed: %v", err)
+	}
+	if !c.Suspended() {
+		t.Error("Expected the control to be suspended")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := c.Resume(); err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	paused := c.SuspendedFor()
+	if c.Suspended() || paused < 20*time.Millisecond {
+		t.Errorf("Expected a resumed control with at least 20ms suspended, got %s", paused)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if c.SuspendedFor() != paused {
+		t.Error("Expected running time not to count as suspended")
+	}
+
+	var nilControl *Control
+	if nilControl.Suspended() || nilControl.SuspendedFor() != 0 {
+		t.Error("Expected a nil control to never be suspended")
+	}
+}
+
+func TestResourceLimits(t *testing.T) {
+	c := Config{MagickMemoryLimit: "1GiB", MagickDiskLimit: "4GiB", FfmpegThreads: 2}
+	if err := c.ValidateResourceLimits(); err != nil {
+		t.Errorf("ValidateResourceLimits() = %v", err)
+	}
+	got := strings.Join(c.BuildMagickArgs("in.heic", "out.jpg"), " ")
+	if want := "-limit memory 1GiB -limit disk 4GiB in.heic"; !strings.HasPrefix(got, want) {
+		t.Errorf("BuildMagickArgs() = %q; want prefix %q", got, want)
+	}
+	args := strings.Join(c.BuildFfmpegArgs("in.mov", "out.mp4"), " ")
+	if !strings.Contains(args, "-threads 2") {
+		t.Errorf("BuildFfmpegArgs() = %q; want -threads 2", args)
+	}
+
+	for _, bad := range []Config{{MagickMemoryLimit: "lots"}, {MagickDiskLimit: "1 GB; rm"}, {MagickThreads: -1}} {
+		if err := bad.ValidateResourceLimits(); err == nil {
+			t.Errorf("Expected %+v to be rejected", bad)
+		}
+	}
+}
+
+func TestPrepareCommandContext_LowPriority(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("priority is a process creation flag on Windows")
+	}
+	if _, err := exec.LookPath("nice"); err != nil {
+		t.Skip("nice is not installed")
+	}
+	cmd := prepareCommandContext(context.Background(), PriorityLow, "ffmpeg", "-i", "in.mov")
+	args := strings.Join(cmd.Args, " ")
+	if !strings.Contains(args, "-n 10 ffmpeg -i in.mov") {
+		t.Errorf("Expected ffmpeg to run under nice, got %q", args)
+	}
+	cmd = prepareCommandContext(context.Background(), PriorityNormal, "ffmpeg", "-i", "in.mov")
+	if got := strings.Join(cmd.Args, " "); got != "ffmpeg -i in.mov" {
+		t.Errorf("Expected an unwrapped command, got %q", got)
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	ctl := &Control{}
+	w := newWatchdog("in.mov", ctl, 30*time.Second, 10*time.Minute)
+	start := w.started
+
+	if err := w.check(start.Add(20 * time.Second)); err != nil {
+		t.Errorf("Expected no timeout after 20s, got %v", err)
+	}
+	err := w.check(start.Add(31 * time.Second))
+	if err == nil || !err.Stalled {
+		t.Fatalf("Expected a stall after 31s without progress, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "no progress for 30s") {
+		t.Errorf("Unexpected error message %q", err.Error())
+	}
+
+	// Progress resets the stall timer but not the time limit.
+	w.lastProgress = start.Add(9 * time.Minute)
+	if err := w.check(start.Add(9*time.Minute + 10*time.Second)); err != nil {
+		t.Errorf("Expected no timeout after recent progress, got %v", err)
+	}
+	w.lastProgress = start.Add(10 * time.Minute)
+	err = w.check(start.Add(10*time.Minute + 10*time.Second))
+	if err == nil || err.Stalled || err.After != 10*time.Minute {
+		t.Errorf("Expected the time limit to be exceeded, got %v", err)
+	}
+
+	// A suspended conversion is never killed.
+	ctl.Suspend()
+	if err := w.check(start.Add(time.Hour)); err != nil {
+		t.Errorf("Expected no timeout while suspended, got %v", err)
+	}
+}
+
+func TestWatchdogObserve(t *testing.T) {
+	w := newWatchdog("in.mov", &Control{}, 30*time.Second, 0)
+	advanced := func(line string) bool {
+		w.lastProgress = time.Time{}
+		if !w.observe(line) {
+			t.Errorf("Expected %q to be a progress line", line)
+		}
+		return !w.lastProgress.IsZero()
+	}
+
+	// Frames count as progress before the time is known.
+	if !advanced("frame=   12 fps=0.0 q=-1.0 size=N/A time=N/A bitrate=N/A speed=N/A") {
+		t.Error("Expected an advancing frame count to feed the watchdog")
+	}
+	if advanced("frame=   12 fps=0.0 q=-1.0 size=N/A time=N/A bitrate=N/A speed=N/A") {
+		t.Error("Expected a repeated frame count not to feed the watchdog")
+	}
+	if !advanced("size=N/A time=00:00:05.00 bitrate=N/A speed=10x") {
+		t.Error("Expected an advancing time to feed the watchdog")
+	}
+	if w.observe("Stream #0:0: Video: h264") {
+		t.Error("Expected a stream line not to be a progress line")
+	}
+}
+
+func TestProgressWriter(t *testing.T) {
+	p := &progressWriter{dog: newWatchdog("in.mov", &Control{}, 30*time.Second, 0)}
+	fmt.Fprint(p, "[Parsed_loudnorm_0] \nsize=N/A time=00:00:01.00 bitrate=N/A\rsize=N/A time=00:00:02.00 bitrate=N/A\r")
+	fmt.Fprint(p, "{\n\t\"input_i\" : \"-20.00\"\n}")
+	p.endLine()
+
+	if got, want := p.out.String(), "[Parsed_loudnorm_0] \n{\n\t\"input_i\" : \"-20.00\"\n}\n"; got != want {
+		t.Errorf("Expected progress lines to be dropped, got %q", got)
+	}
+	if p.dog.lastTime != 2*time.Second {
+		t.Errorf("Expected the watchdog to see time 2s, got %s", p.dog.lastTime)
+	}
+}
+
+func TestTimeLimit(t *testing.T) {
+	c := Config{TimeoutFactor: 4}
+	if got := c.TimeLimit(time.Hour); got != 4*time.Hour {
+		t.Errorf("TimeLimit(1h) = %s, want 4h", got)
+	}
+	if got := c.TimeLimit(10 * time.Second); got != minTimeLimit {
+		t.Errorf("TimeLimit(10s) = %s, want %s", got, minTimeLimit)
+	}
+	if got := c.TimeLimit(0); got != 0 {
+		t.Errorf("TimeLimit(0) = %s, want no limit", got)
+	}
+	if got := (&Config{}).TimeLimit(time.Hour); got != 0 {
+		t.Errorf("Expected no limit without a factor, got %s", got)
+	}
+}
+
+func TestBuildFfmpegArgs_MergesMovflags(t *testing.T) {
+	c := Config{HardwareAccelerator: "none", FfmpegCustomArgs: "-preset slow -movflags +faststart"}
+	args := c.buildFfmpegArgs("input.mov", "output.mp4", nil, nil)
+	joined := strings.Join(args, "|")
+	if strings.Count(joined, "-movflags") != 1 || !strings.Contains(joined, "-movflags|+use_metadata_tags+faststart") {
+		t.Errorf("Expected one merged -movflags, got %q", joined)
+	}
+
+	c = Config{HardwareAccelerator: "none", VideoMetadata: MetadataStrip, FfmpegCustomArgs: "-movflags faststart"}
+	joined = strings.Join(c.buildFfmpegArgs("input.mov", "output.mp4", nil, nil), "|")
+	if !strings.Contains(joined, "-movflags|+faststart|") {
+		t.Errorf("Expected the custom -movflags alone, got %q", joined)
+	}
+}
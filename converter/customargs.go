@@ -0,0 +1,203 @@
+package converter
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// CustomArgs are user supplied ffmpeg options for one side of the command.
+type CustomArgs struct {
+	Input  string `json:"input" mapstructure:"input"`   // placed before -i
+	Output string `json:"output" mapstructure:"output"` // placed before the output path
+}
+
+// CustomArgsError lists custom arguments that would override or break the
+// arguments generated by the converter.
+type CustomArgsError struct {
+	Conflicts []string
+}
+
+func (e *CustomArgsError) Error() string {
+	return fmt.Sprintf("custom ffmpeg arguments conflict with generated ones: %s", strings.Join(e.Conflicts, ", "))
+}
+
+// SplitArgs splits a command line into arguments. Whitespace separates
+// arguments unless it is quoted with single or double quotes. A backslash
+// escapes a following quote or whitespace and is kept literally otherwise,
+// so Windows paths need no doubling.
+func SplitArgs(s string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\\' && i+1 < len(runes) && quote != '\'' &&
+			(runes[i+1] == '"' || runes[i+1] == '\'' || runes[i+1] == '\\' || (quote == 0 && unicode.IsSpace(runes[i+1]))):
+			i++
+			current.WriteRune(runes[i])
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in %q", quote, s)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// customArgs returns the parsed input and output custom arguments, with the
+// entry for the active accelerator replacing the generic ones.
+func (c *Config) customArgs() (input, output []string, err error) {
+	inputStr, outputStr := c.FfmpegInputArgs, c.FfmpegCustomArgs
+	if override, ok := c.FfmpegAcceleratorArgs[strings.ToLower(c.HardwareAccelerator)]; ok {
+		if override.Input != "" {
+			inputStr = override.Input
+		}
+		if override.Output != "" {
+			outputStr = override.Output
+		}
+	}
+
+	if input, err = SplitArgs(inputStr); err != nil {
+		return nil, nil, fmt.Errorf("invalid custom input arguments: %w", err)
+	}
+	if output, err = SplitArgs(outputStr); err != nil {
+		return nil, nil, fmt.Errorf("invalid custom output arguments: %w", err)
+	}
+	return input, output, nil
+}
+
+// generatedOptions are options the converter always sets itself. Repeating
+// them in custom arguments either silently replaces the generated value or
+// makes ffmpeg reject the command.
+var generatedOptions = map[string]bool{
+	"-i":              true,
+	"-vf":             true,
+	"-filter:v":       true,
+	"-filter_complex": true,
+	"-lavfi":          true,
+	"-y":              true,
+	"-n":              true,
+}
+
+// flagOptions are ffmpeg options that take no value, so an argument after
+// them is positional. Boolean options also have a "-no" form, e.g.
+// -noautorotate.
+var flagOptions = map[string]bool{
+	"-an":               true,
+	"-vn":               true,
+	"-sn":               true,
+	"-dn":               true,
+	"-y":                true,
+	"-n":                true,
+	"-shortest":         true,
+	"-re":               true,
+	"-copyts":           true,
+	"-start_at_zero":    true,
+	"-accurate_seek":    true,
+	"-autorotate":       true,
+	"-autoscale":        true,
+	"-fix_sub_duration": true,
+	"-nostdin":          true,
+	"-stdin":            true,
+	"-stats":            true,
+	"-hide_banner":      true,
+	"-ignore_unknown":   true,
+	"-copy_unknown":     true,
+	"-benchmark":        true,
+	"-benchmark_all":    true,
+	"-xerror":           true,
+	"-debug_ts":         true,
+	"-dump":             true,
+	"-hex":              true,
+	"-vstats":           true,
+}
+
+// takesValue reports whether the option arg is followed by its value.
+func takesValue(arg string) bool {
+	if flagOptions[arg] {
+		return false
+	}
+	if name, ok := strings.CutPrefix(arg, "-no"); ok && flagOptions["-"+name] {
+		return false
+	}
+	return true
+}
+
+// ValidateCustomArgs checks the custom arguments of every accelerator for
+// quoting errors and conflicts with the generated arguments.
+func (c *Config) ValidateCustomArgs() error {
+	configs := []*Config{c}
+	for accel := range c.FfmpegAcceleratorArgs {
+		variant := *c
+		variant.HardwareAccelerator = accel
+		configs = append(configs, &variant)
+	}
+
+	var conflicts []string
+	seen := make(map[string]bool)
+	for _, cfg := range configs {
+		input, output, err := cfg.customArgs()
+		if err != nil {
+			return err
+		}
+		for _, conflict := range append(cfg.findConflicts(input, "input"), cfg.findConflicts(output, "output")...) {
+			if !seen[conflict] {
+				seen[conflict] = true
+				conflicts = append(conflicts, conflict)
+			}
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return &CustomArgsError{Conflicts: conflicts}
+	}
+	return nil
+}
+
+// findConflicts reports generated options and stray positional arguments,
+// which ffmpeg would treat as additional output files. An argument is a
+// value when it follows an option that takes one.
+func (c *Config) findConflicts(args []string, side string) []string {
+	var conflicts []string
+	expectValue := false
+	for _, arg := range args {
+		isOption := strings.HasPrefix(arg, "-") && len(arg) > 1
+		switch {
+		case isOption && generatedOptions[arg]:
+			conflicts = append(conflicts, fmt.Sprintf("%s (%s)", arg, side))
+		case isOption && c.AudioNormalize && (arg == "-af" || arg == "-filter:a"):
+			conflicts = append(conflicts, fmt.Sprintf("%s (%s, used by loudness normalization)", arg, side))
+		case !isOption && !expectValue:
+			conflicts = append(conflicts, fmt.Sprintf("%q (%s, looks like an output path)", arg, side))
+		}
+		expectValue = isOption && takesValue(arg)
+	}
+	return conflicts
+}
@@ -0,0 +1,367 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+)
+
+// EXIF tags handled by the image metadata policies.
+const (
+	tagOrientation = 0x0112
+	tagDateTime    = 0x0132
+	tagExifIFD     = 0x8769
+	tagGPSIFD      = 0x8825
+)
+
+// dateTags are the Exif IFD tags kept by the "minimal" image policy.
+var dateTags = map[uint16]bool{
+	0x9003: true, // DateTimeOriginal
+	0x9004: true, // DateTimeDigitized
+	0x9010: true, // OffsetTime
+	0x9011: true, // OffsetTimeOriginal
+	0x9012: true, // OffsetTimeDigitized
+	0x9290: true, // SubSecTime
+	0x9291: true, // SubSecTimeOriginal
+	0x9292: true, // SubSecTimeDigitized
+}
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	xmpPrefix  = []byte("http://ns.adobe.com/")
+	// xmpMainPrefix starts the main XMP packet; other XMP segments hold
+	// extended XMP, split in checksummed chunks.
+	xmpMainPrefix = []byte("http://ns.adobe.com/xap/1.0/\x00")
+
+	// xmpGPSAttr and xmpGPSElem match the exif:GPS* properties of an XMP
+	// packet, written as attributes or as elements.
+	xmpGPSAttr = regexp.MustCompile(`\s+exif:GPS\w*\s*=\s*(?:"[^"]*"|'[^']*')`)
+	xmpGPSElem = regexp.MustCompile(`(?s)\s*<exif:GPS\w*(?:\s[^>]*)?(?:/>|>.*?</exif:GPS\w*>)`)
+)
+
+const (
+	markerSOS  = 0xDA
+	markerEOI  = 0xD9
+	markerAPP1 = 0xE1
+	markerAPPD = 0xED // IPTC / Photoshop resources
+	markerCOM  = 0xFE
+)
+
+type jpegSegment struct {
+	marker byte
+	data   []byte // payload without the marker and length
+}
+
+// splitJPEG returns the segments before the first scan and the remaining
+// bytes starting at the SOS marker.
+func splitJPEG(data []byte) ([]jpegSegment, []byte, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, nil, fmt.Errorf("not a JPEG file")
+	}
+
+	var segments []jpegSegment
+	pos := 2
+	for {
+		if pos+2 > len(data) || data[pos] != 0xFF {
+			return nil, nil, fmt.Errorf("corrupt JPEG marker at offset %d", pos)
+		}
+		marker := data[pos+1]
+		if marker == 0xFF {
+			pos++ // fill byte
+			continue
+		}
+		if marker == markerSOS || marker == markerEOI {
+			return segments, data[pos:], nil
+		}
+		if pos+4 > len(data) {
+			return nil, nil, fmt.Errorf("truncated JPEG segment at offset %d", pos)
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil, nil, fmt.Errorf("invalid JPEG segment length at offset %d", pos)
+		}
+		segments = append(segments, jpegSegment{marker: marker, data: data[pos+4 : pos+2+length]})
+		pos += 2 + length
+	}
+}
+
+func joinJPEG(segments []jpegSegment, scan []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0xFF, 0xD8})
+	for _, s := range segments {
+		buf.Write([]byte{0xFF, s.marker})
+		binary.Write(&buf, binary.BigEndian, uint16(len(s.data)+2))
+		buf.Write(s.data)
+	}
+	buf.Write(scan)
+	return buf.Bytes()
+}
+
+func (s jpegSegment) isExif() bool {
+	return s.marker == markerAPP1 && bytes.HasPrefix(s.data, exifHeader)
+}
+
+func (s jpegSegment) isXMP() bool {
+	return s.marker == markerAPP1 && bytes.HasPrefix(s.data, xmpPrefix)
+}
+
+// scrubXMPGPS removes the exif:GPS* properties from an XMP packet and keeps
+// everything else, such as ratings and keywords.
+func scrubXMPGPS(data []byte) []byte {
+	data = xmpGPSElem.ReplaceAll(data, nil)
+	return xmpGPSAttr.ReplaceAll(data, nil)
+}
+
+type exifEntry struct {
+	tag         uint16
+	typ         uint16
+	count       uint32
+	value       []byte // raw value, in the byte order of the file
+	valueOffset uint32 // offset of an out-of-line value, 0 when stored inline
+}
+
+var exifTypeSizes = map[uint16]uint64{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
+}
+
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// newTIFFReader parses the TIFF header of an EXIF block and returns the
+// offset of IFD0.
+func newTIFFReader(data []byte) (*tiffReader, uint32, error) {
+	if len(data) < 8 {
+		return nil, 0, fmt.Errorf("EXIF block too short")
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, 0, fmt.Errorf("invalid TIFF byte order")
+	}
+	return &tiffReader{data: data, order: order}, order.Uint32(data[4:]), nil
+}
+
+func (r *tiffReader) readIFD(offset uint32) ([]exifEntry, error) {
+	if uint64(offset)+2 > uint64(len(r.data)) {
+		return nil, fmt.Errorf("IFD offset %d out of range", offset)
+	}
+	n := uint64(r.order.Uint16(r.data[offset:]))
+	if uint64(offset)+2+12*n > uint64(len(r.data)) {
+		return nil, fmt.Errorf("IFD at %d is truncated", offset)
+	}
+
+	entries := make([]exifEntry, 0, n)
+	for i := uint64(0); i < n; i++ {
+		pos := uint64(offset) + 2 + 12*i
+		e := exifEntry{
+			tag:   r.order.Uint16(r.data[pos:]),
+			typ:   r.order.Uint16(r.data[pos+2:]),
+			count: r.order.Uint32(r.data[pos+4:]),
+		}
+		size := exifTypeSizes[e.typ] * uint64(e.count)
+		if size <= 4 {
+			e.value = r.data[pos+8 : pos+8+size]
+		} else {
+			e.valueOffset = r.order.Uint32(r.data[pos+8:])
+			if uint64(e.valueOffset)+size > uint64(len(r.data)) {
+				return nil, fmt.Errorf("value of tag 0x%04x out of range", e.tag)
+			}
+			e.value = r.data[e.valueOffset : uint64(e.valueOffset)+size]
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// subIFD reads the IFD referenced by the pointer tag in entries, if any.
+func (r *tiffReader) subIFD(entries []exifEntry, tag uint16) (uint32, []exifEntry, error) {
+	for _, e := range entries {
+		if e.tag == tag && len(e.value) == 4 {
+			offset := r.order.Uint32(e.value)
+			sub, err := r.readIFD(offset)
+			return offset, sub, err
+		}
+	}
+	return 0, nil, nil
+}
+
+// scrubGPS clears the GPS IFD in place: every GPS value is zeroed and the IFD
+// is left with no entries. Offsets of other tags, such as maker notes, stay
+// valid because nothing is moved.
+func scrubGPS(tiff []byte) error {
+	r, ifd0Offset, err := newTIFFReader(tiff)
+	if err != nil {
+		return err
+	}
+	ifd0, err := r.readIFD(ifd0Offset)
+	if err != nil {
+		return err
+	}
+	gpsOffset, gps, err := r.subIFD(ifd0, tagGPSIFD)
+	if err != nil || gps == nil {
+		return err
+	}
+
+	for _, e := range gps {
+		if e.valueOffset != 0 {
+			clear(e.value)
+		}
+	}
+	// Entries and the next-IFD pointer.
+	clear(tiff[gpsOffset : uint64(gpsOffset)+2+12*uint64(len(gps))+4])
+	return nil
+}
+
+// minimalExif rebuilds an EXIF block keeping only the orientation and the
+// capture date tags.
+func minimalExif(tiff []byte) ([]byte, error) {
+	r, ifd0Offset, err := newTIFFReader(tiff)
+	if err != nil {
+		return nil, err
+	}
+	ifd0, err := r.readIFD(ifd0Offset)
+	if err != nil {
+		return nil, err
+	}
+	_, exif, err := r.subIFD(ifd0, tagExifIFD)
+	if err != nil {
+		return nil, err
+	}
+
+	var keep0, keepExif []exifEntry
+	for _, e := range ifd0 {
+		if e.tag == tagOrientation || e.tag == tagDateTime {
+			keep0 = append(keep0, e)
+		}
+	}
+	for _, e := range exif {
+		if dateTags[e.tag] {
+			keepExif = append(keepExif, e)
+		}
+	}
+
+	return buildTIFF(r.order, keep0, map[uint16][]exifEntry{tagExifIFD: keepExif}), nil
+}
+
+// buildTIFF writes IFD0 and the given sub-IFDs, adding the pointer tags that
+// link them. Values are copied unchanged, so they must already be in order.
+func buildTIFF(order binary.ByteOrder, ifd0 []exifEntry, subs map[uint16][]exifEntry) []byte {
+	ifd0 = append([]exifEntry(nil), ifd0...)
+	var subTags []uint16
+	for tag, entries := range subs {
+		if len(entries) > 0 {
+			subTags = append(subTags, tag)
+			ifd0 = append(ifd0, exifEntry{tag: tag, typ: 4, count: 1, value: make([]byte, 4)})
+		}
+	}
+	sort.Slice(subTags, func(i, j int) bool { return subTags[i] < subTags[j] })
+	sort.Slice(ifd0, func(i, j int) bool { return ifd0[i].tag < ifd0[j].tag })
+
+	ifdSize := func(n int) uint32 { return uint32(2 + 12*n + 4) }
+
+	// IFD0 directly follows the header, the sub-IFDs follow IFD0 and all
+	// out-of-line values come last.
+	subOffsets := make(map[uint16]uint32)
+	next := 8 + ifdSize(len(ifd0))
+	for _, tag := range subTags {
+		subOffsets[tag] = next
+		next += ifdSize(len(subs[tag]))
+	}
+
+	buf := make([]byte, next)
+	if order == binary.LittleEndian {
+		copy(buf, "II")
+	} else {
+		copy(buf, "MM")
+	}
+	order.PutUint16(buf[2:], 42)
+	order.PutUint32(buf[4:], 8)
+
+	writeIFD := func(offset uint32, entries []exifEntry) {
+		order.PutUint16(buf[offset:], uint16(len(entries)))
+		for i, e := range entries {
+			pos := offset + 2 + 12*uint32(i)
+			order.PutUint16(buf[pos:], e.tag)
+			order.PutUint16(buf[pos+2:], e.typ)
+			order.PutUint32(buf[pos+4:], e.count)
+
+			value := e.value
+			if subOffset, ok := subOffsets[e.tag]; ok {
+				value = make([]byte, 4)
+				order.PutUint32(value, subOffset)
+			}
+			if len(value) <= 4 {
+				copy(buf[pos+8:pos+12], value)
+				continue
+			}
+			if len(buf)%2 == 1 {
+				buf = append(buf, 0)
+			}
+			order.PutUint32(buf[pos+8:], uint32(len(buf)))
+			buf = append(buf, value...)
+		}
+	}
+
+	writeIFD(8, ifd0)
+	for _, tag := range subTags {
+		writeIFD(subOffsets[tag], subs[tag])
+	}
+	return buf
+}
+
+// applyImageMetadata rewrites the metadata of a JPEG file according to the
+// "no-gps" or "minimal" policy. Other policies are handled by magick itself.
+func applyImageMetadata(path, policy string) error {
+	if policy != MetadataNoGPS && policy != MetadataMinimal {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	segments, scan, err := splitJPEG(data)
+	if err != nil {
+		return err
+	}
+
+	kept := segments[:0]
+	for _, s := range segments {
+		switch {
+		case s.isXMP() && policy == MetadataNoGPS && bytes.HasPrefix(s.data, xmpMainPrefix):
+			// XMP can repeat the GPS position.
+			s.data = scrubXMPGPS(s.data)
+		case s.isXMP():
+			// Extended XMP cannot be edited without its checksum, and
+			// minimal keeps no XMP at all.
+			continue
+		case policy == MetadataMinimal && (s.marker == markerAPPD || s.marker == markerCOM):
+			continue
+		case s.isExif():
+			tiff := s.data[len(exifHeader):]
+			if policy == MetadataNoGPS {
+				if err := scrubGPS(tiff); err != nil {
+					return fmt.Errorf("could not remove GPS tags: %w", err)
+				}
+			} else {
+				rebuilt, err := minimalExif(tiff)
+				if err != nil {
+					return fmt.Errorf("could not rewrite EXIF: %w", err)
+				}
+				s.data = append(append([]byte(nil), exifHeader...), rebuilt...)
+			}
+		}
+		kept = append(kept, s)
+	}
+
+	return os.WriteFile(path, joinJPEG(kept, scan), 0644)
+}
@@ -0,0 +1,220 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testLatitude = []byte{
+	37, 0, 0, 0, 1, 0, 0, 0, // 37/1
+	33, 0, 0, 0, 1, 0, 0, 0, // 33/1
+	0x10, 0x27, 0, 0, 100, 0, 0, 0, // 10000/100
+}
+
+func asciiEntry(tag uint16, s string) exifEntry {
+	v := append([]byte(s), 0)
+	return exifEntry{tag: tag, typ: 2, count: uint32(len(v)), value: v}
+}
+
+// testXMP carries a rating, keywords and the GPS position both as
+// attributes and as elements.
+const testXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmlns:exif="http://ns.adobe.com/exif/1.0/" xmlns:dc="http://purl.org/dc/elements/1.1/"
+ xmp:Rating="5" exif:GPSLatitude="37,33.50N" exif:GPSLongitude='126,58.70E'>
+<exif:GPSAltitude>41/1</exif:GPSAltitude>
+<exif:GPSVersionID/>
+<dc:subject><rdf:Bag><rdf:li>holiday</rdf:li></rdf:Bag></dc:subject>
+</rdf:Description></rdf:RDF></x:xmpmeta>`
+
+// writeTestJPEG writes a small JPEG carrying EXIF with device, date and GPS
+// tags and an XMP packet.
+func writeTestJPEG(t *testing.T) string {
+	t.Helper()
+
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewGray(image.Rect(0, 0, 4, 4)), nil); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+
+	orientation := make([]byte, 2)
+	binary.LittleEndian.PutUint16(orientation, 6)
+	tiff := buildTIFF(binary.LittleEndian,
+		[]exifEntry{
+			asciiEntry(0x010F, "Apple"),
+			asciiEntry(0x0110, "iPhone 15 Pro"),
+			{tag: tagOrientation, typ: 3, count: 1, value: orientation},
+			asciiEntry(tagDateTime, "2024:01:01 21:00:00"),
+		},
+		map[uint16][]exifEntry{
+			tagExifIFD: {
+				asciiEntry(0x9003, "2024:01:01 21:00:00"),
+				asciiEntry(0x9011, "+09:00"),
+				asciiEntry(0xA434, "iPhone 15 Pro back camera"),
+			},
+			tagGPSIFD: {
+				asciiEntry(0x0001, "N"),
+				{tag: 0x0002, typ: 5, count: 3, value: testLatitude},
+			},
+		},
+	)
+
+	segments, scan, err := splitJPEG(img.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to split JPEG: %v", err)
+	}
+	segments = append([]jpegSegment{
+		{marker: markerAPP1, data: append(append([]byte(nil), exifHeader...), tiff...)},
+		{marker: markerAPP1, data: []byte("http://ns.adobe.com/xap/1.0/\x00" + testXMP)},
+	}, segments...)
+
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	if err := os.WriteFile(path, joinJPEG(segments, scan), 0644); err != nil {
+		t.Fatalf("Failed to write JPEG: %v", err)
+	}
+	return path
+}
+
+// readExifTags reads back the tags of a JPEG file per IFD.
+func readExifTags(t *testing.T, path string) (data []byte, tags map[string]map[uint16]bool, hasXMP bool) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	segments, _, err := splitJPEG(data)
+	if err != nil {
+		t.Fatalf("Output is not a valid JPEG: %v", err)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatalf("Output does not decode: %v", err)
+	}
+
+	tags = map[string]map[uint16]bool{"ifd0": {}, "exif": {}, "gps": {}}
+	for _, s := range segments {
+		if s.isXMP() {
+			hasXMP = true
+		}
+		if !s.isExif() {
+			continue
+		}
+		r, offset, err := newTIFFReader(s.data[len(exifHeader):])
+		if err != nil {
+			t.Fatalf("Invalid TIFF header: %v", err)
+		}
+		ifd0, err := r.readIFD(offset)
+		if err != nil {
+			t.Fatalf("Invalid IFD0: %v", err)
+		}
+		for _, e := range ifd0 {
+			tags["ifd0"][e.tag] = true
+		}
+		for name, tag := range map[string]uint16{"exif": tagExifIFD, "gps": tagGPSIFD} {
+			_, sub, err := r.subIFD(ifd0, tag)
+			if err != nil {
+				t.Fatalf("Invalid %s IFD: %v", name, err)
+			}
+			for _, e := range sub {
+				tags[name][e.tag] = true
+			}
+		}
+	}
+	return data, tags, hasXMP
+}
+
+func TestApplyImageMetadata_Keep(t *testing.T) {
+	path := writeTestJPEG(t)
+	if err := applyImageMetadata(path, MetadataKeep); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, tags, hasXMP := readExifTags(t, path)
+	if !tags["gps"][0x0002] || !tags["ifd0"][0x010F] || !hasXMP {
+		t.Errorf("Expected all metadata to be kept, got %v (xmp=%v)", tags, hasXMP)
+	}
+}
+
+func TestApplyImageMetadata_NoGPS(t *testing.T) {
+	path := writeTestJPEG(t)
+	if err := applyImageMetadata(path, MetadataNoGPS); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data, tags, hasXMP := readExifTags(t, path)
+	if len(tags["gps"]) != 0 {
+		t.Errorf("Expected no GPS tags, got %v", tags["gps"])
+	}
+	if bytes.Contains(data, testLatitude) {
+		t.Error("Expected GPS coordinates to be erased from the file")
+	}
+	if !tags["ifd0"][0x010F] || !tags["ifd0"][0x0110] || !tags["exif"][0xA434] {
+		t.Errorf("Expected device tags to be kept, got %v", tags)
+	}
+	// Only the GPS properties are removed from XMP.
+	if !hasXMP || !bytes.Contains(data, []byte(`xmp:Rating="5"`)) || !bytes.Contains(data, []byte("<rdf:li>holiday</rdf:li>")) {
+		t.Error("Expected XMP with its rating and keywords to be kept")
+	}
+	if bytes.Contains(data, []byte("GPS")) {
+		t.Error("Expected the GPS properties to be removed from XMP")
+	}
+}
+
+func TestApplyImageMetadata_Minimal(t *testing.T) {
+	path := writeTestJPEG(t)
+	if err := applyImageMetadata(path, MetadataMinimal); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data, tags, hasXMP := readExifTags(t, path)
+	want := map[string][]uint16{
+		"ifd0": {tagOrientation, tagDateTime, tagExifIFD},
+		"exif": {0x9003, 0x9011},
+		"gps":  {},
+	}
+	for ifd, wantTags := range want {
+		if len(tags[ifd]) != len(wantTags) {
+			t.Errorf("Expected %s tags %x, got %v", ifd, wantTags, tags[ifd])
+		}
+		for _, tag := range wantTags {
+			if !tags[ifd][tag] {
+				t.Errorf("Expected %s tag 0x%04x to be kept", ifd, tag)
+			}
+		}
+	}
+	if bytes.Contains(data, []byte("Apple")) || bytes.Contains(data, testLatitude) {
+		t.Error("Expected device and GPS values to be removed from the file")
+	}
+	if hasXMP {
+		t.Error("Expected XMP to be removed")
+	}
+}
+
+func TestBuildMagickArgs_Metadata(t *testing.T) {
+	for policy, wantStrip := range map[string]bool{
+		MetadataKeep:    false,
+		MetadataNoGPS:   false,
+		MetadataMinimal: false,
+		MetadataStrip:   true,
+	} {
+		c := Config{ImageMetadata: policy}
+		hasStrip := false
+		for _, a := range c.BuildMagickArgs("in.heic", "out.jpg") {
+			if a == "-strip" {
+				hasStrip = true
+			}
+		}
+		if hasStrip != wantStrip {
+			t.Errorf("policy %q: expected -strip=%v", policy, wantStrip)
+		}
+	}
+
+	c := Config{ImageMetadata: MetadataNoGPS}
+	args := c.BuildMagickArgs("in.heic", "out.png")
+	if args[len(args)-2] != "-strip" {
+		t.Errorf("Expected non-JPEG outputs to fall back to -strip, got %v", args)
+	}
+}
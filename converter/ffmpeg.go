@@ -3,8 +3,10 @@ package converter
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"strconv"
 	"strings"
 	"sync"
@@ -12,6 +14,10 @@ import (
 )
 
 func (c *Config) BuildFfmpegArgs(orig, dest string) []string {
+	return c.buildFfmpegArgs(orig, dest, nil, nil)
+}
+
+func (c *Config) buildFfmpegArgs(orig, dest string, info *MediaInfo, loudness *loudnessStats) []string {
 	args := []string{
 		"-hide_banner",
 		"-loglevel", "info",
@@ -50,16 +56,31 @@ func (c *Config) BuildFfmpegArgs(orig, dest string) []string {
 		nvidiaPreset = "slow"
 	}
 
+	hevc := c.isHevc()
+
+	// Ffmpeg validates the custom arguments before building the command.
+	customInput, customOutput, err := c.customArgs()
+	if err != nil {
+		log.Printf("Ignoring custom ffmpeg arguments: %v", err)
+	}
+	if len(customInput) > 0 {
+		log.Printf("Adding custom ffmpeg input arguments: %q", customInput)
+	}
+
 	accelerator := strings.ToLower(c.HardwareAccelerator)
 	switch accelerator {
 	case "amd":
-		log.Println("Using 'amd' hardware accelerator (h264_amf) from config.")
+		encoder := "h264_amf"
+		if hevc {
+			encoder = "hevc_amf"
+		}
+		log.Printf("Using 'amd' hardware accelerator (%s) from config.", encoder)
+		args = append(args, c.inputArgs(orig, customInput...)...)
 		args = append(args,
-			"-i", orig,
-			"-c:v", "h264_amf",
+			"-c:v", encoder,
 			"-b:v", bitrate,
 			"-quality", amdQuality,
-			"-vf", strings.Replace(scaleArg, "scale", "vpp_amf", 1),
+			"-vf", c.videoFilter(strings.Replace(scaleArg, "scale", "vpp_amf", 1)),
 		)
 
 		// Recommended settings from https://github.com/GPUOpen-LibrariesAndSDKs/AMF/wiki/Recommended-FFmpeg-Encoder-Settings
@@ -72,8 +93,10 @@ func (c *Config) BuildFfmpegArgs(orig, dest string) []string {
 				"-vbaq", "true",
 				"-preencode", "true",
 				"-high_motion_quality_boost_enable", "true",
-				"-bf", "3",
 			)
+			if !hevc {
+				args = append(args, "-bf", "3")
+			}
 		case "balanced": // Medium
 			args = append(args,
 				"-rc", "vbr_peak",
@@ -82,45 +105,133 @@ func (c *Config) BuildFfmpegArgs(orig, dest string) []string {
 				"-vbaq", "true",
 				"-preencode", "true",
 				"-high_motion_quality_boost_enable", "true",
-				"-bf", "3",
 			)
+			if !hevc {
+				args = append(args, "-bf", "3")
+			}
 		case "speed": // Low
 		}
 	case "nvidia":
-		log.Println("Using 'nvidia' hardware accelerator (h264_nvenc) from config.")
+		encoder := "h264_nvenc"
+		if hevc {
+			encoder = "hevc_nvenc"
+		}
+		log.Printf("Using 'nvidia' hardware accelerator (%s) from config.", encoder)
 		// User reported success with software scale + format=yuv420p
+		args = append(args, "-hwaccel", "cuda")
+		args = append(args, c.inputArgs(orig, customInput...)...)
 		args = append(args,
-			"-hwaccel", "cuda",
-			"-i", orig,
-			"-c:v", "h264_nvenc",
+			"-c:v", encoder,
 			"-preset", nvidiaPreset,
 			"-b:v", bitrate,
-			"-vf", scaleArg+",format=yuv420p",
+			"-vf", c.videoFilter(scaleArg+",format=yuv420p"),
 		)
 	case "none", "":
-		log.Println("Using software encoder (libx264).")
-		args = append(args, "-i", orig, "-c:v", "libx264", "-vf", scaleArg)
+		encoder := softwareEncoder(hevc)
+		log.Printf("Using software encoder (%s).", encoder)
+		args = append(args, c.inputArgs(orig, customInput...)...)
+		args = append(args, "-c:v", encoder, "-vf", c.videoFilter(scaleArg))
 	default:
-		log.Printf("Unknown hardwareAccelerator '%s', falling back to software encoder (libx264).", accelerator)
-		args = append(args, "-i", orig, "-c:v", "libx264", "-vf", scaleArg)
+		encoder := softwareEncoder(hevc)
+		log.Printf("Unknown hardwareAccelerator '%s', falling back to software encoder (%s).", accelerator, encoder)
+		args = append(args, c.inputArgs(orig, customInput...)...)
+		args = append(args, "-c:v", encoder, "-vf", c.videoFilter(scaleArg))
+	}
+
+	args = append(args, c.trimArgs()...)
+
+	if info != nil && info.Rotation != 0 {
+		// Frames are already rotated upright by the decoder; make sure the
+		// source's rotation tag is not carried over and applied again.
+		args = append(args, "-metadata:s:v:0", "rotate=0")
 	}
 
-	if c.FfmpegCustomArgs != "" {
-		log.Printf("Adding custom ffmpeg arguments: %s", c.FfmpegCustomArgs)
-		args = append(args, strings.Fields(c.FfmpegCustomArgs)...)
+	args = append(args, c.videoMetadataArgs(info)...)
+
+	if hevc {
+		// Apple players only recognize HEVC in MP4 with the hvc1 tag.
+		args = append(args, "-tag:v", "hvc1")
 	}
 
-	args = append(args,
-		"-c:a", "aac",
-		dest,
-	)
+	if c.FfmpegThreads > 0 {
+		args = append(args, "-threads", strconv.Itoa(c.FfmpegThreads))
+	}
+
+	if len(customOutput) > 0 {
+		log.Printf("Adding custom ffmpeg output arguments: %q", customOutput)
+		args = append(args, customOutput...)
+	}
+
+	args = append(args, c.audioArgs(info, loudness)...)
+	args = mergeMovflags(args, lastIndex(args, "-i")+2)
+	args = append(args, dest)
 
 	return args
 }
 
+// videoFilter prepends the rotate, flip and crop filters to the scale filter.
+func (c *Config) videoFilter(scale string) string {
+	return strings.Join(append(c.transformFilters(), scale), ",")
+}
+
+// isHevc reports whether VideoCodec selects H.265 instead of the default H.264.
+func (c *Config) isHevc() bool {
+	switch strings.ToLower(c.VideoCodec) {
+	case "hevc", "h265":
+		return true
+	}
+	return false
+}
+
+func softwareEncoder(hevc bool) string {
+	if hevc {
+		return "libx265"
+	}
+	return "libx264"
+}
+
 func (c *Config) Ffmpeg(ctx context.Context, orig, dest string, onProgress ProgressCallback) error {
-	args := c.BuildFfmpegArgs(orig, dest)
-	cmd := prepareCommandContext(ctx, c.FfmpegBinary, args...)
+	info, err := c.ProbeMedia(ctx, orig)
+	if err != nil {
+		log.Printf("Could not probe input, continuing without stream info: %v", err)
+		info = nil
+	}
+
+	var probedDuration time.Duration
+	if info != nil {
+		probedDuration = info.Duration
+	}
+	if err := c.validateTrim(probedDuration); err != nil {
+		return err
+	}
+	if err := c.validateTransform(); err != nil {
+		return err
+	}
+	if err := c.ValidateCustomArgs(); err != nil {
+		return err
+	}
+	if err := c.validateVideoMetadata(); err != nil {
+		return err
+	}
+
+	var loudness *loudnessStats
+	if c.AudioNormalize && !c.AudioMute && (info == nil || info.AudioCodec != "") {
+		loudness, err = c.measureLoudness(ctx, orig, probedDuration)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("Falling back to single-pass loudness normalization: %v", err)
+		}
+	}
+
+	ctl := controlFrom(ctx)
+	dog := newWatchdog(orig, ctl, c.StallTimeout, c.TimeLimit(c.clipDuration(probedDuration)))
+	watchCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	args := c.buildFfmpegArgs(orig, dest, info, loudness)
+	cmd := prepareCommandContext(watchCtx, c.Priority, c.FfmpegBinary, args...)
 
 	// Ensure standard input is closed to prevent ffmpeg from waiting for input
 	cmd.Stdin = nil
@@ -133,6 +244,10 @@ func (c *Config) Ffmpeg(ctx context.Context, orig, dest string, onProgress Progr
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("could not start ffmpeg: %w", err)
 	}
+	started := time.Now()
+	ctl.attach(cmd.Process)
+	defer ctl.detach()
+	go dog.run(watchCtx, cancel)
 
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -159,7 +274,8 @@ func (c *Config) Ffmpeg(ctx context.Context, orig, dest string, onProgress Progr
 
 			// Debug logging for ffmpeg output to diagnose hangs/errors
 			// Only log lines that don't look like standard progress to avoid flooding logs too much.
-			isProgress := timeRegex.MatchString(line)
+			// Progress lines keep the watchdog fed, even before the duration is known.
+			isProgress := dog.observe(line)
 			if !isProgress {
 				log.Printf("ffmpeg: %s", line)
 			}
@@ -167,39 +283,39 @@ func (c *Config) Ffmpeg(ctx context.Context, orig, dest string, onProgress Progr
 			if duration == 0 {
 				matches := durationRegex.FindStringSubmatch(line)
 				if len(matches) == 5 {
-					h, _ := strconv.Atoi(matches[1])
-					m, _ := strconv.Atoi(matches[2])
-					s, _ := strconv.Atoi(matches[3])
-
-					nanos := parseFractionToNanos(matches[4])
-					duration = time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second + time.Duration(nanos)*time.Nanosecond
-					log.Printf("Detected video duration: %s", duration)
+					duration = c.clipDuration(parseTimestamp(matches))
+					log.Printf("Detected output duration: %s", duration)
+					if probedDuration == 0 {
+						dog.setLimit(c.TimeLimit(duration))
+					}
 				}
 			}
 
 			if duration > 0 {
 				matches := timeRegex.FindStringSubmatch(line)
 				if len(matches) == 5 {
-					h, _ := strconv.Atoi(matches[1])
-					m, _ := strconv.Atoi(matches[2])
-					s, _ := strconv.Atoi(matches[3])
-
-					nanos := parseFractionToNanos(matches[4])
-					currentTime := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second + time.Duration(nanos)*time.Nanosecond
+					currentTime := parseTimestamp(matches)
 
 					progress := int((float64(currentTime) / float64(duration)) * 100)
 					if progress > 100 {
 						progress = 100
 					}
 
-					var speed string
+					var speed float64
 					speedMatch := speedRegex.FindStringSubmatch(line)
 					if len(speedMatch) > 1 {
-						speed = speedMatch[1] + "x"
+						speed, _ = strconv.ParseFloat(speedMatch[1], 64)
+					}
+					// ffmpeg measures its speed against the wall clock, which
+					// includes the time the process was suspended.
+					if paused := ctl.SuspendedFor(); paused > 0 {
+						if active := time.Since(started) - paused; active > 0 {
+							speed = float64(currentTime) / float64(active)
+						}
 					}
 
 					if onProgress != nil {
-						onProgress(progress, speed)
+						onProgress(progress, formatSpeed(speed), estimateRemaining(duration-currentTime, speed))
 					}
 				}
 			}
@@ -212,11 +328,33 @@ func (c *Config) Ffmpeg(ctx context.Context, orig, dest string, onProgress Progr
 		stderrMu.Lock()
 		logs := strings.Join(stderrLog, "\n")
 		stderrMu.Unlock()
+		var timeout *TimeoutError
+		if errors.As(context.Cause(watchCtx), &timeout) {
+			log.Printf("Killed ffmpeg for %s: %v", orig, timeout)
+			return fmt.Errorf("%w. Log: %s", timeout, logs)
+		}
 		return fmt.Errorf("ffmpeg finished with error: %w. Log: %s", err, logs)
 	}
 	return nil
 }
 
+// formatSpeed formats an encoding speed like ffmpeg does, e.g. "1.5x".
+func formatSpeed(speed float64) string {
+	if speed <= 0 {
+		return ""
+	}
+	return strconv.FormatFloat(math.Round(speed*100)/100, 'f', -1, 64) + "x"
+}
+
+// estimateRemaining returns the time needed to encode the remaining media
+// time at speed, or 0 when it cannot be estimated.
+func estimateRemaining(remaining time.Duration, speed float64) time.Duration {
+	if speed <= 0 || remaining <= 0 {
+		return 0
+	}
+	return time.Duration(float64(remaining) / speed).Round(time.Second)
+}
+
 // parseFractionToNanos converts a fractional second string (e.g. "50") to nanoseconds.
 // It pads or truncates the string to 9 digits to represent nanoseconds.
 // For example: "5" -> 500000000 (500ms), "123" -> 123000000 (123ms).
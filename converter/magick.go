@@ -1,21 +1,78 @@
 package converter
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
 )
 
+func (c *Config) BuildMagickArgs(orig, dest string) []string {
+	var args []string
+	// Resource limits are settings and must precede the input to apply to it.
+	if c.MagickMemoryLimit != "" {
+		args = append(args, "-limit", "memory", c.MagickMemoryLimit)
+	}
+	if c.MagickDiskLimit != "" {
+		args = append(args, "-limit", "disk", c.MagickDiskLimit)
+	}
+	args = append(args, orig)
+	args = append(args, c.magickTransformArgs()...)
+	args = append(args, c.magickMetadataArgs(dest)...)
+	return append(args, dest)
+}
+
 func (c *Config) Magick(ctx context.Context, orig, dest string) error {
-	cmd := prepareCommandContext(ctx, c.MagickBinary, orig, dest)
+	if err := c.validateTransform(); err != nil {
+		return err
+	}
+	if err := c.validateImageMetadata(); err != nil {
+		return err
+	}
+	if err := c.ValidateResourceLimits(); err != nil {
+		return err
+	}
+
+	cmd := prepareCommandContext(ctx, c.Priority, c.MagickBinary, c.BuildMagickArgs(orig, dest)...)
 	// Ensure standard input is closed to prevent magick from waiting for input
 	cmd.Stdin = nil
+	if c.MagickThreads > 0 {
+		cmd.Env = append(os.Environ(), "MAGICK_THREAD_LIMIT="+strconv.Itoa(c.MagickThreads))
+	}
 	log.Printf("Running magick command: %s", cmd.String())
 
-	// Use CombinedOutput to avoid hanging on Windows GUI if stdout/stderr are not consumed.
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("magick failed: %w. Output: %s", err, string(output))
+	// Collect stdout and stderr to avoid hanging on Windows GUI if they are not consumed.
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := runControlled(ctx, cmd); err != nil {
+		return fmt.Errorf("magick failed: %w. Output: %s", err, output.String())
+	}
+
+	if isJPEG(dest) {
+		if err := applyImageMetadata(dest, strings.ToLower(c.ImageMetadata)); err != nil {
+			return fmt.Errorf("could not apply image metadata policy: %w", err)
+		}
+	}
+	return nil
+}
+
+// magickLimitRegex matches ImageMagick resource sizes such as "512MB" or "1GiB".
+var magickLimitRegex = regexp.MustCompile(`^\d+(\.\d+)?\s*([KMGTP]i?)?B?$`)
+
+// ValidateResourceLimits checks the ImageMagick memory and disk limits.
+func (c *Config) ValidateResourceLimits() error {
+	for name, limit := range map[string]string{"memory": c.MagickMemoryLimit, "disk": c.MagickDiskLimit} {
+		if limit != "" && !magickLimitRegex.MatchString(limit) {
+			return fmt.Errorf("invalid ImageMagick %s limit %q, expected a size such as 512MiB or 2GiB", name, limit)
+		}
+	}
+	if c.FfmpegThreads < 0 || c.MagickThreads < 0 {
+		return fmt.Errorf("thread limits must not be negative")
 	}
 	return nil
 }
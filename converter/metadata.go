@@ -0,0 +1,132 @@
+package converter
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Metadata policies. Video outputs support keep, dates and strip; image
+// outputs support keep, no-gps, minimal and strip.
+const (
+	MetadataKeep    = "keep"    // copy all metadata, including location and device
+	MetadataDates   = "dates"   // keep only the capture date
+	MetadataNoGPS   = "no-gps"  // remove the GPS position only
+	MetadataMinimal = "minimal" // keep only the orientation and the capture date
+	MetadataStrip   = "strip"   // remove all metadata
+)
+
+// validateVideoMetadata checks the VideoMetadata policy.
+func (c *Config) validateVideoMetadata() error {
+	switch strings.ToLower(c.VideoMetadata) {
+	case "", MetadataKeep, MetadataDates, MetadataStrip:
+		return nil
+	}
+	return fmt.Errorf("unknown video metadata policy %q, expected keep, dates or strip", c.VideoMetadata)
+}
+
+// videoMetadataArgs returns the output options implementing the
+// VideoMetadata policy. info may be nil when the source was not probed.
+func (c *Config) videoMetadataArgs(info *MediaInfo) []string {
+	switch strings.ToLower(c.VideoMetadata) {
+	case MetadataStrip:
+		return []string{"-map_metadata", "-1", "-map_chapters", "-1"}
+	case MetadataDates:
+		args := []string{"-map_metadata", "-1"}
+		if info == nil {
+			log.Println("Source was not probed, the capture date cannot be kept.")
+			return args
+		}
+		if !info.CreationTime.IsZero() {
+			args = append(args, "-metadata", "creation_time="+info.CreationTime.UTC().Format(time.RFC3339Nano))
+		}
+		if info.QuickTimeCreationDate != "" {
+			// The QuickTime key keeps the local time zone of the capture.
+			args = append(args,
+				"-movflags", "+use_metadata_tags",
+				"-metadata", "com.apple.quicktime.creationdate="+info.QuickTimeCreationDate,
+			)
+		}
+		return args
+	default:
+		// Without use_metadata_tags the mp4 muxer drops the QuickTime keys
+		// holding the location and the device make and model.
+		return []string{"-map_metadata", "0", "-movflags", "+use_metadata_tags"}
+	}
+}
+
+// mergeMovflags combines the -movflags options among args[from:], the output
+// options, into one at the place of the first. ffmpeg only applies the last
+// -movflags given, so a custom "-movflags +faststart" would otherwise drop
+// the generated +use_metadata_tags. Flags without a sign are added too.
+func mergeMovflags(args []string, from int) []string {
+	var flags []string
+	first := -1
+	merged := args[:from:from]
+	for i := from; i < len(args); i++ {
+		if args[i] != "-movflags" || i+1 == len(args) {
+			merged = append(merged, args[i])
+			continue
+		}
+		i++
+		flag := args[i]
+		if !strings.HasPrefix(flag, "+") && !strings.HasPrefix(flag, "-") {
+			flag = "+" + flag
+		}
+		flags = append(flags, flag)
+		if first < 0 {
+			first = len(merged)
+			merged = append(merged, "-movflags", "")
+		}
+	}
+	if first >= 0 {
+		merged[first+1] = strings.Join(flags, "")
+	}
+	return merged
+}
+
+// lastIndex returns the index of the last occurrence of s in args, or -1.
+func lastIndex(args []string, s string) int {
+	for i := len(args) - 1; i >= 0; i-- {
+		if args[i] == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// validateImageMetadata checks the ImageMetadata policy.
+func (c *Config) validateImageMetadata() error {
+	switch strings.ToLower(c.ImageMetadata) {
+	case "", MetadataKeep, MetadataNoGPS, MetadataMinimal, MetadataStrip:
+		return nil
+	}
+	return fmt.Errorf("unknown image metadata policy %q, expected keep, no-gps, minimal or strip", c.ImageMetadata)
+}
+
+// magickMetadataArgs returns the ImageMagick options for the ImageMetadata
+// policy. The no-gps and minimal policies rewrite the EXIF block of JPEG
+// outputs after conversion; other formats fall back to stripping everything
+// so that no location is leaked.
+func (c *Config) magickMetadataArgs(dest string) []string {
+	switch strings.ToLower(c.ImageMetadata) {
+	case MetadataStrip:
+		return []string{"-strip"}
+	case MetadataNoGPS, MetadataMinimal:
+		if !isJPEG(dest) {
+			log.Printf("Metadata policy %q is only supported for JPEG, stripping all metadata of %s.", c.ImageMetadata, dest)
+			return []string{"-strip"}
+		}
+	}
+	return nil
+}
+
+func isJPEG(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MediaInfo describes the streams of a media file as reported by ffmpeg.
+type MediaInfo struct {
+	Duration      time.Duration
+	VideoCodec    string
+	Width         int
+	Height        int
+	AudioCodec    string
+	AudioChannels int
+	Rotation      int // clockwise display rotation from the container metadata
+
+	CreationTime          time.Time // creation_time of the container, in UTC
+	QuickTimeCreationDate string    // com.apple.quicktime.creationdate, with the local offset
+}
+
+var (
+	videoStreamRegex   = regexp.MustCompile(`Stream #\d+:\d+.*?: Video: (\w+).*?, (\d{2,5})x(\d{2,5})`)
+	audioStreamRegex   = regexp.MustCompile(`Stream #\d+:\d+.*?: Audio: (\w+)[^,]*, (\d+) Hz, ([^,]+)`)
+	displayMatrixRegex = regexp.MustCompile(`displaymatrix: rotation of (-?[\d\.]+) degrees`)
+	rotateTagRegex     = regexp.MustCompile(`(?m)^\s+rotate\s+: (-?\d+)`)
+	creationTimeRegex  = regexp.MustCompile(`(?m)^\s+creation_time\s+: (\S+)`)
+	qtCreationRegex    = regexp.MustCompile(`(?m)^\s+com\.apple\.quicktime\.creationdate\s*: (\S+)`)
+)
+
+// ProbeMedia runs ffmpeg against the input without an output and parses the
+// stream information it prints. A probe prints no progress, so it is killed
+// when it runs longer than the stall timeout, e.g. on a hung network share.
+func (c *Config) ProbeMedia(ctx context.Context, path string) (*MediaInfo, error) {
+	var output []byte
+	dog := newWatchdog(path, controlFrom(ctx), c.StallTimeout, 0)
+	err := dog.watch(ctx, func(ctx context.Context) error {
+		cmd := prepareCommandContext(ctx, c.Priority, c.FfmpegBinary, "-hide_banner", "-i", path)
+		cmd.Stdin = nil
+		var err error
+		output, err = cmd.CombinedOutput()
+		return err
+	})
+	var timeout *TimeoutError
+	if errors.As(err, &timeout) {
+		return nil, timeout
+	}
+
+	// ffmpeg exits with an error when no output is given, so the exit status is
+	// ignored as long as the input header was printed.
+	if !strings.Contains(string(output), "Input #0") {
+		return nil, fmt.Errorf("probe failed: %v. Output: %s", err, string(output))
+	}
+
+	return parseMediaInfo(string(output)), nil
+}
+
+func parseMediaInfo(output string) *MediaInfo {
+	info := &MediaInfo{}
+
+	if matches := durationRegex.FindStringSubmatch(output); len(matches) == 5 {
+		info.Duration = parseTimestamp(matches)
+	}
+
+	if matches := videoStreamRegex.FindStringSubmatch(output); len(matches) == 4 {
+		info.VideoCodec = matches[1]
+		info.Width, _ = strconv.Atoi(matches[2])
+		info.Height, _ = strconv.Atoi(matches[3])
+	}
+
+	if matches := audioStreamRegex.FindStringSubmatch(output); len(matches) == 4 {
+		info.AudioCodec = matches[1]
+		info.AudioChannels = parseChannelLayout(matches[3])
+	}
+
+	// The display matrix angle is counter-clockwise, the legacy rotate tag clockwise.
+	if matches := displayMatrixRegex.FindStringSubmatch(output); len(matches) == 2 {
+		deg, _ := strconv.ParseFloat(matches[1], 64)
+		info.Rotation = normalizeRotation(-int(deg))
+	} else if matches := rotateTagRegex.FindStringSubmatch(output); len(matches) == 2 {
+		deg, _ := strconv.Atoi(matches[1])
+		info.Rotation = normalizeRotation(deg)
+	}
+
+	// The container block comes first, so the first match is the file's own
+	// creation time rather than one of a stream.
+	if matches := creationTimeRegex.FindStringSubmatch(output); len(matches) == 2 {
+		if t, err := time.Parse(time.RFC3339Nano, matches[1]); err == nil {
+			info.CreationTime = t.UTC()
+		}
+	}
+	if matches := qtCreationRegex.FindStringSubmatch(output); len(matches) == 2 {
+		info.QuickTimeCreationDate = matches[1]
+	}
+
+	return info
+}
+
+// normalizeRotation maps any angle onto 0, 90, 180 or 270 degrees.
+func normalizeRotation(deg int) int {
+	deg %= 360
+	if deg < 0 {
+		deg += 360
+	}
+	return deg
+}
+
+// parseChannelLayout converts an ffmpeg channel layout name into a channel count.
+func parseChannelLayout(layout string) int {
+	layout = strings.TrimSpace(layout)
+	switch {
+	case layout == "mono":
+		return 1
+	case layout == "stereo":
+		return 2
+	case layout == "quad":
+		return 4
+	case strings.HasPrefix(layout, "5.1"):
+		return 6
+	case strings.HasPrefix(layout, "7.1"):
+		return 8
+	case strings.HasSuffix(layout, " channels"):
+		n, _ := strconv.Atoi(strings.TrimSuffix(layout, " channels"))
+		return n
+	}
+	return 0
+}
+
+// parseTimestamp converts the four submatches of durationRegex or timeRegex
+// (hours, minutes, seconds, fraction) into a time.Duration.
+func parseTimestamp(matches []string) time.Duration {
+	h, _ := strconv.Atoi(matches[1])
+	m, _ := strconv.Atoi(matches[2])
+	s, _ := strconv.Atoi(matches[3])
+
+	nanos := parseFractionToNanos(matches[4])
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second + time.Duration(nanos)*time.Nanosecond
+}
+
+// probeImageSize returns the upright dimensions of an image, swapping width
+// and height when the EXIF orientation rotates it by 90 degrees.
+func (c *Config) probeImageSize(ctx context.Context, path string) (int, int, error) {
+	cmd := prepareCommandContext(ctx, c.Priority, c.MagickBinary, "identify", "-format", "%w %h %[orientation]", path+"[0]")
+	cmd.Stdin = nil
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, 0, fmt.Errorf("identify failed: %w", err)
+	}
+	return parseImageSize(string(output))
+}
+
+func parseImageSize(output string) (int, int, error) {
+	fields := strings.Fields(output)
+	if len(fields) < 2 {
+		return 0, 0, fmt.Errorf("unexpected identify output: %q", output)
+	}
+	w, errW := strconv.Atoi(fields[0])
+	h, errH := strconv.Atoi(fields[1])
+	if errW != nil || errH != nil {
+		return 0, 0, fmt.Errorf("unexpected identify output: %q", output)
+	}
+	// LeftTop, RightTop, RightBottom and LeftBottom are the rotated orientations.
+	if len(fields) > 2 && (strings.HasPrefix(fields[2], "Left") || strings.HasPrefix(fields[2], "Right")) {
+		w, h = h, w
+	}
+	return w, h, nil
+}
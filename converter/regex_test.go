@@ -2,6 +2,7 @@ package converter
 
 import (
 	"testing"
+	"time"
 )
 
 func TestRegexParsing(t *testing.T) {
@@ -59,3 +60,151 @@ func TestRegexParsing(t *testing.T) {
 		})
 	}
 }
+
+func TestParseMediaInfo(t *testing.T) {
+	output := `Input #0, mov,mp4,m4a,3gp,3g2,mj2, from 'IMG_0001.MOV':
+  Metadata:
+    major_brand     : qt  
+    creation_time   : 2024-01-01T12:00:00.000000Z
+    com.apple.quicktime.creationdate: 2024-01-01T21:00:00+0900
+  Duration: 00:00:10.03, start: 0.000000, bitrate: 12345 kb/s
+  Stream #0:0[0x1](und): Video: hevc (Main) (hvc1 / 0x31637668), yuv420p(tv, bt709), 1920x1080, 12000 kb/s, 29.98 fps, 30 tbr, 600 tbn (default)
+  Stream #0:1[0x2](und): Audio: aac (LC) (mp4a / 0x6134706D), 44100 Hz, stereo, fltp, 160 kb/s (default)
+At least one output file must be specified`
+
+	info := parseMediaInfo(output)
+	if info.VideoCodec != "hevc" || info.Width != 1920 || info.Height != 1080 {
+		t.Errorf("Unexpected video info: %+v", info)
+	}
+	if info.AudioCodec != "aac" || info.AudioChannels != 2 {
+		t.Errorf("Unexpected audio info: %+v", info)
+	}
+	if info.CreationTime.Format(time.RFC3339) != "2024-01-01T12:00:00Z" {
+		t.Errorf("Expected creation time 2024-01-01T12:00:00Z, got %s", info.CreationTime)
+	}
+	if info.QuickTimeCreationDate != "2024-01-01T21:00:00+0900" {
+		t.Errorf("Expected QuickTime creation date, got %q", info.QuickTimeCreationDate)
+	}
+	if info.Duration.Milliseconds() != 10030 {
+		t.Errorf("Expected duration 10.03s, got %s", info.Duration)
+	}
+
+	rotated := parseMediaInfo(`  Stream #0:0: Video: h264 (High), yuv420p, 1920x1080
+    Side data:
+      displaymatrix: rotation of -90.00 degrees`)
+	if rotated.Rotation != 90 {
+		t.Errorf("Expected display matrix rotation 90, got %d", rotated.Rotation)
+	}
+
+	legacy := parseMediaInfo(`  Stream #0:0: Video: h264 (High), yuv420p, 1920x1080
+    Metadata:
+      rotate          : 270`)
+	if legacy.Rotation != 270 {
+		t.Errorf("Expected rotate tag 270, got %d", legacy.Rotation)
+	}
+
+	for layout, want := range map[string]int{"mono": 1, "stereo": 2, "5.1(side)": 6, "3 channels": 3, "unknown": 0} {
+		if got := parseChannelLayout(layout); got != want {
+			t.Errorf("parseChannelLayout(%q) = %d; want %d", layout, got, want)
+		}
+	}
+}
+
+func TestParseExifDateTime(t *testing.T) {
+	got, err := parseExifDateTime("2024:01:01 21:00:00|+09:00\n")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	got, err = parseExifDateTime("2024:01:01 21:00:00|")
+	if err != nil || got.Location() != time.Local || got.Hour() != 21 {
+		t.Errorf("Expected local 21:00 without offset, got %s, %v", got, err)
+	}
+
+	if _, err := parseExifDateTime("|"); err == nil {
+		t.Error("Expected error without a capture date")
+	}
+}
+
+func TestVideoCaptureTime(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("UTC+9", 9*60*60)
+	defer func() { time.Local = local }()
+
+	// 20:00 UTC is already the next day at UTC+9.
+	info := &MediaInfo{CreationTime: time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC)}
+	got, ok := videoCaptureTime(info)
+	if !ok {
+		t.Fatal("Expected a capture time from creation_time")
+	}
+	if d := got.Format("2006-01-02"); d != "2024-01-02" {
+		t.Errorf("Expected local date 2024-01-02, got %s", d)
+	}
+
+	info.QuickTimeCreationDate = "2024-01-01T23:30:00-0500"
+	got, _ = videoCaptureTime(info)
+	if d := got.Format("2006-01-02 15:04"); d != "2024-01-01 23:30" {
+		t.Errorf("Expected the QuickTime date to keep its offset, got %s", d)
+	}
+
+	if _, ok := videoCaptureTime(&MediaInfo{}); ok {
+		t.Error("Expected no capture time without creation dates")
+	}
+}
+
+func TestParseImageSize(t *testing.T) {
+	tests := []struct {
+		output string
+		w, h   int
+	}{
+		{"4032 3024 TopLeft", 4032, 3024},
+		{"4032 3024 RightTop", 3024, 4032},
+		{"4032 3024 Undefined", 4032, 3024},
+		{"640 480 ", 640, 480},
+	}
+	for _, tt := range tests {
+		w, h, err := parseImageSize(tt.output)
+		if err != nil || w != tt.w || h != tt.h {
+			t.Errorf("parseImageSize(%q) = %d, %d, %v; want %d, %d", tt.output, w, h, err, tt.w, tt.h)
+		}
+	}
+	if _, _, err := parseImageSize("garbage"); err == nil {
+		t.Error("Expected error for invalid output")
+	}
+
+	if w, h := fitWithin(3840, 2160, 1280); w != 1280 || h != 720 {
+		t.Errorf("fitWithin landscape = %dx%d; want 1280x720", w, h)
+	}
+	if w, h := fitWithin(1080, 1920, 1280); w != 720 || h != 1280 {
+		t.Errorf("fitWithin portrait = %dx%d; want 720x1280", w, h)
+	}
+}
+
+func TestParseFilenameDate(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		want time.Time
+		ok   bool
+	}{
+		{"IMG_20240101_123456.jpg", time.Date(2024, 1, 1, 12, 34, 56, 0, time.Local), true},
+		{"VID_20231231_235959.mp4", time.Date(2023, 12, 31, 23, 59, 59, 0, time.Local), true},
+		{"20240101_123456.heic", time.Date(2024, 1, 1, 12, 34, 56, 0, time.Local), true},
+		{"PXL_20240101_123456789.jpg", time.Date(2024, 1, 1, 12, 34, 56, 0, time.UTC), true},
+		{"IMG-20240101-WA0001.jpg", time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local), true},
+		{"Screenshot 2024-03-05 at 10.11.12.png", time.Date(2024, 3, 5, 10, 11, 12, 0, time.Local), true},
+		{"holiday 2024-03-05.mov", time.Date(2024, 3, 5, 0, 0, 0, 0, time.Local), true},
+		{"IMG_0001.HEIC", time.Time{}, false},
+		{"IMG_20991231_000000.jpg", time.Time{}, false},
+		{"IMG_20241301_000000.jpg", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseFilenameDate(tt.name, now)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("parseFilenameDate(%q) = %s, %v; want %s, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
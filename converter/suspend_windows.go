@@ -0,0 +1,43 @@
+//go:build windows
+
+package converter
+
+import (
+	"os"
+	"syscall"
+)
+
+// processSuspendResume is the PROCESS_SUSPEND_RESUME access right.
+const processSuspendResume = 0x0800
+
+var (
+	ntdll            = syscall.NewLazyDLL("ntdll.dll")
+	ntSuspendProcess = ntdll.NewProc("NtSuspendProcess")
+	ntResumeProcess  = ntdll.NewProc("NtResumeProcess")
+)
+
+func suspendProcess(p *os.Process) error {
+	return callProcess(ntSuspendProcess, p)
+}
+
+func resumeProcess(p *os.Process) error {
+	return callProcess(ntResumeProcess, p)
+}
+
+// callProcess calls an ntdll function that takes a process handle and
+// returns an NTSTATUS.
+func callProcess(proc *syscall.LazyProc, p *os.Process) error {
+	h, err := syscall.OpenProcess(processSuspendResume, false, uint32(p.Pid))
+	if err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(h)
+
+	if err := proc.Find(); err != nil {
+		return err
+	}
+	if status, _, _ := proc.Call(uintptr(h)); status != 0 {
+		return syscall.Errno(status)
+	}
+	return nil
+}
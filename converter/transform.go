@@ -0,0 +1,147 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// CropRect is a crop rectangle in pixels, measured on the upright image, i.e.
+// after the source's own rotation metadata has been applied.
+type CropRect struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// IsZero reports whether no crop is requested.
+func (r CropRect) IsZero() bool {
+	return r.Width == 0 && r.Height == 0
+}
+
+var cropGeometryRegex = regexp.MustCompile(`^(\d+)x(\d+)\+(\d+)\+(\d+)$`)
+
+// ParseCropRect parses an ImageMagick style geometry such as "1080x1080+420+0".
+func ParseCropRect(s string) (CropRect, error) {
+	matches := cropGeometryRegex.FindStringSubmatch(s)
+	if len(matches) != 5 {
+		return CropRect{}, fmt.Errorf("invalid crop geometry %q, expected WxH+X+Y", s)
+	}
+	w, _ := strconv.Atoi(matches[1])
+	h, _ := strconv.Atoi(matches[2])
+	x, _ := strconv.Atoi(matches[3])
+	y, _ := strconv.Atoi(matches[4])
+	return CropRect{X: x, Y: y, Width: w, Height: h}, nil
+}
+
+// validateTransform checks the rotation and crop options.
+func (c *Config) validateTransform() error {
+	switch c.Rotate {
+	case 0, 90, 180, 270:
+	default:
+		return fmt.Errorf("unsupported rotation %d, expected 0, 90, 180 or 270", c.Rotate)
+	}
+	if !c.Crop.IsZero() && (c.Crop.Width <= 0 || c.Crop.Height <= 0 || c.Crop.X < 0 || c.Crop.Y < 0) {
+		return fmt.Errorf("invalid crop rectangle %+v", c.Crop)
+	}
+	return nil
+}
+
+// hasTransform reports whether any rotate, flip or crop option is set.
+func (c *Config) hasTransform() bool {
+	return c.Rotate != 0 || c.FlipH || c.FlipV || !c.Crop.IsZero()
+}
+
+// transformFilters returns the ffmpeg filters for the crop, flip and rotate
+// options, in that order. ffmpeg auto-rotates decoded frames according to the
+// source's display matrix and drops it from the output, so the filters work
+// on upright frames and the result is never rotated twice.
+func (c *Config) transformFilters() []string {
+	var filters []string
+	if !c.Crop.IsZero() {
+		filters = append(filters, fmt.Sprintf("crop=%d:%d:%d:%d", c.Crop.Width, c.Crop.Height, c.Crop.X, c.Crop.Y))
+	}
+	if c.FlipH {
+		filters = append(filters, "hflip")
+	}
+	if c.FlipV {
+		filters = append(filters, "vflip")
+	}
+	switch c.Rotate {
+	case 90:
+		filters = append(filters, "transpose=clock")
+	case 180:
+		filters = append(filters, "hflip", "vflip")
+	case 270:
+		filters = append(filters, "transpose=cclock")
+	}
+	return filters
+}
+
+// magickTransformArgs returns the ImageMagick operators matching
+// transformFilters. -auto-orient bakes the EXIF orientation into the pixels
+// and resets the tag, so viewers do not rotate the result a second time.
+func (c *Config) magickTransformArgs() []string {
+	args := []string{"-auto-orient"}
+	if !c.Crop.IsZero() {
+		args = append(args, "-crop", fmt.Sprintf("%dx%d+%d+%d", c.Crop.Width, c.Crop.Height, c.Crop.X, c.Crop.Y), "+repage")
+	}
+	if c.FlipH {
+		args = append(args, "-flop")
+	}
+	if c.FlipV {
+		args = append(args, "-flip")
+	}
+	if c.Rotate != 0 {
+		args = append(args, "-rotate", strconv.Itoa(c.Rotate))
+	}
+	return args
+}
+
+// OutputSize predicts the dimensions of the converted file: the upright
+// source size, cropped and rotated, and for videos scaled to fit MaxSize.
+func (c *Config) OutputSize(ctx context.Context, path string) (int, int, error) {
+	var w, h int
+	video := IsVideoFile(path)
+	if video {
+		info, err := c.ProbeMedia(ctx, path)
+		if err != nil {
+			return 0, 0, err
+		}
+		w, h = info.Width, info.Height
+		if info.Rotation == 90 || info.Rotation == 270 {
+			w, h = h, w
+		}
+	} else {
+		var err error
+		if w, h, err = c.probeImageSize(ctx, path); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if !c.Crop.IsZero() {
+		w, h = c.Crop.Width, c.Crop.Height
+	}
+	if c.Rotate == 90 || c.Rotate == 270 {
+		w, h = h, w
+	}
+	if video {
+		w, h = fitWithin(w, h, c.MaxSize)
+	}
+	return w, h, nil
+}
+
+// fitWithin mirrors scale's force_original_aspect_ratio=decrease: the largest
+// size with the same aspect ratio that fits a max x max box.
+func fitWithin(w, h, max int) (int, int) {
+	if max <= 0 || w <= 0 || h <= 0 {
+		return w, h
+	}
+	if w >= h {
+		return max, int(math.Round(float64(h) * float64(max) / float64(w)))
+	}
+	return int(math.Round(float64(w) * float64(max) / float64(h))), max
+}
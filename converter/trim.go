@@ -0,0 +1,87 @@
+package converter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// inputArgs returns the input options for orig, seeking to TrimStart when set
+// and placing custom options directly before -i. Placing -ss before -i seeks
+// the demuxer to the nearest keyframe and then decodes up to the exact
+// position, which is both fast and frame accurate when transcoding.
+func (c *Config) inputArgs(orig string, custom ...string) []string {
+	var args []string
+	if c.TrimStart > 0 {
+		args = append(args, "-ss", formatSeconds(c.TrimStart))
+	}
+	args = append(args, custom...)
+	return append(args, "-i", orig)
+}
+
+// trimArgs returns the output options limiting the clip length to TrimEnd.
+func (c *Config) trimArgs() []string {
+	if c.TrimEnd <= 0 {
+		return nil
+	}
+	// Input-side seeking resets timestamps to zero, so the end point is
+	// expressed as a length rather than an absolute position.
+	return []string{"-t", formatSeconds(c.TrimEnd - c.TrimStart)}
+}
+
+// validateTrim checks the trim range against the probed duration, which may
+// be zero when unknown.
+func (c *Config) validateTrim(duration time.Duration) error {
+	if c.TrimStart < 0 || c.TrimEnd < 0 {
+		return fmt.Errorf("trim times must not be negative")
+	}
+	if c.TrimEnd > 0 && c.TrimEnd <= c.TrimStart {
+		return fmt.Errorf("trim end (%s) must be after trim start (%s)", c.TrimEnd, c.TrimStart)
+	}
+	if duration > 0 && c.TrimStart >= duration {
+		return fmt.Errorf("trim start (%s) is beyond the end of the video (%s)", c.TrimStart, duration)
+	}
+	return nil
+}
+
+// clipDuration returns the length of the output for an input of the given
+// duration once the trim range is applied.
+func (c *Config) clipDuration(duration time.Duration) time.Duration {
+	end := duration
+	if c.TrimEnd > 0 && c.TrimEnd < end {
+		end = c.TrimEnd
+	}
+	if c.TrimStart >= end {
+		return duration
+	}
+	return end - c.TrimStart
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// ParseTimecode parses a position given as seconds ("12.5") or as
+// [HH:]MM:SS[.fraction] ("01:02.5", "00:01:02.5").
+func ParseTimecode(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timecode: %s", s)
+	}
+
+	var total float64
+	for _, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid timecode: %s", s)
+		}
+		total = total*60 + v
+	}
+	return time.Duration(total * float64(time.Second)), nil
+}
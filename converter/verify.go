@@ -0,0 +1,115 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// VerificationError reports a converted file that does not match what the
+// conversion should have produced.
+type VerificationError struct {
+	Path   string
+	Reason string
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("output verification failed: %s", e.Reason)
+}
+
+// Verify checks the output of a finished conversion of orig into dest:
+// videos are probed for their duration and streams, images are identified
+// and their dimensions compared with the expected ones.
+func (c *Config) Verify(ctx context.Context, orig, dest string) error {
+	info, err := os.Stat(dest)
+	if err != nil {
+		return &VerificationError{Path: dest, Reason: fmt.Sprintf("output is missing: %v", err)}
+	}
+	if info.Size() == 0 {
+		return &VerificationError{Path: dest, Reason: "output is empty"}
+	}
+
+	if IsVideoFile(orig) {
+		return c.verifyVideo(ctx, orig, dest)
+	}
+	return c.verifyImage(ctx, orig, dest)
+}
+
+func (c *Config) verifyVideo(ctx context.Context, orig, dest string) error {
+	out, err := c.ProbeMedia(ctx, dest)
+	if err != nil {
+		return &VerificationError{Path: dest, Reason: fmt.Sprintf("output cannot be read: %v", err)}
+	}
+	// Without input information only the output itself can be checked.
+	in, err := c.ProbeMedia(ctx, orig)
+	if err != nil {
+		in = nil
+	}
+	if reason := c.checkVideoOutput(in, out); reason != "" {
+		return &VerificationError{Path: dest, Reason: reason}
+	}
+	return nil
+}
+
+// checkVideoOutput compares the probed output with the probed input and
+// returns why they do not match, or "" when they do. in may be nil.
+func (c *Config) checkVideoOutput(in, out *MediaInfo) string {
+	if out.VideoCodec == "" {
+		return "output has no video stream"
+	}
+	if out.Duration <= 0 {
+		return "output has no duration"
+	}
+	if c.AudioMute && out.AudioCodec != "" {
+		return "output has an audio stream although audio is muted"
+	}
+	if in == nil {
+		return ""
+	}
+
+	if !c.AudioMute && in.AudioCodec != "" && out.AudioCodec == "" {
+		return "output is missing the audio stream"
+	}
+	if in.Duration > 0 {
+		want := c.clipDuration(in.Duration)
+		diff := out.Duration - want
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff > durationTolerance(want) {
+			return fmt.Sprintf("output duration %s does not match the expected %s", out.Duration.Round(time.Millisecond), want.Round(time.Millisecond))
+		}
+	}
+	return ""
+}
+
+// durationTolerance allows for container and frame rounding: 2% of the
+// expected duration, but at least half a second.
+func durationTolerance(want time.Duration) time.Duration {
+	tolerance := want / 50
+	if tolerance < 500*time.Millisecond {
+		tolerance = 500 * time.Millisecond
+	}
+	return tolerance
+}
+
+func (c *Config) verifyImage(ctx context.Context, orig, dest string) error {
+	w, h, err := c.probeImageSize(ctx, dest)
+	if err != nil {
+		return &VerificationError{Path: dest, Reason: fmt.Sprintf("output cannot be read: %v", err)}
+	}
+	if w <= 0 || h <= 0 {
+		return &VerificationError{Path: dest, Reason: fmt.Sprintf("output has invalid dimensions %dx%d", w, h)}
+	}
+
+	wantW, wantH, err := c.OutputSize(ctx, orig)
+	if err != nil {
+		// The source could not be identified; the output itself is valid.
+		return nil
+	}
+	if w != wantW || h != wantH {
+		return &VerificationError{Path: dest, Reason: fmt.Sprintf("output is %dx%d, expected %dx%d", w, h, wantW, wantH)}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// minTimeLimit is the shortest wall-clock limit, so that short clips are not
+// killed while the encoder is still starting.
+const minTimeLimit = 5 * time.Minute
+
+// TimeoutError reports a conversion that was killed because it stopped
+// making progress or ran longer than its time limit.
+type TimeoutError struct {
+	Path string
+	// Stalled is set when no progress arrived for After; otherwise the
+	// conversion ran for After, its time limit.
+	Stalled bool
+	After   time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Stalled {
+		return fmt.Sprintf("conversion stalled: no progress for %s", e.After.Round(time.Second))
+	}
+	return fmt.Sprintf("conversion timed out after %s", e.After.Round(time.Second))
+}
+
+// TimeLimit returns the wall-clock limit for converting media of the given
+// duration: TimeoutFactor times the duration, but at least minTimeLimit. It
+// is 0, meaning no limit, when the factor or the duration is unknown.
+func (c *Config) TimeLimit(duration time.Duration) time.Duration {
+	if c.TimeoutFactor <= 0 || duration <= 0 {
+		return 0
+	}
+	return max(minTimeLimit, time.Duration(float64(duration)*c.TimeoutFactor))
+}
+
+// watchdog kills a conversion that stalls or runs too long. Time spent
+// suspended through its Control does not count.
+type watchdog struct {
+	mu   sync.Mutex
+	path string
+	ctl  *Control
+
+	stall time.Duration
+	limit time.Duration
+
+	started time.Time
+	// lastProgress and pausedAtProgress are the time and the total
+	// suspended time when progress last arrived.
+	lastProgress     time.Time
+	pausedAtProgress time.Duration
+	// lastTime and lastFrame are the furthest time= and frame= values
+	// ffmpeg printed.
+	lastTime  time.Duration
+	lastFrame int64
+}
+
+func newWatchdog(path string, ctl *Control, stall, limit time.Duration) *watchdog {
+	now := time.Now()
+	return &watchdog{path: path, ctl: ctl, stall: stall, limit: limit, started: now, lastProgress: now}
+}
+
+// progress records that the conversion advanced.
+func (w *watchdog) progress() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lastProgress = time.Now()
+	w.pausedAtProgress = w.ctl.SuspendedFor()
+}
+
+// observe feeds a line of ffmpeg output to the watchdog. Any time= or
+// frame= value past the furthest one seen counts as progress, whether or not
+// the duration of the media is known. It reports whether the line is a
+// progress line.
+func (w *watchdog) observe(line string) bool {
+	timeMatch := timeRegex.FindStringSubmatch(line)
+	frameMatch := frameRegex.FindStringSubmatch(line)
+	if timeMatch == nil && frameMatch == nil {
+		return false
+	}
+
+	advanced := false
+	w.mu.Lock()
+	if len(timeMatch) == 5 {
+		if t := parseTimestamp(timeMatch); t > w.lastTime {
+			w.lastTime = t
+			advanced = true
+		}
+	}
+	if len(frameMatch) == 2 {
+		if n, err := strconv.ParseInt(frameMatch[1], 10, 64); err == nil && n > w.lastFrame {
+			w.lastFrame = n
+			advanced = true
+		}
+	}
+	w.mu.Unlock()
+
+	if advanced {
+		w.progress()
+	}
+	return true
+}
+
+// setLimit changes the wall-clock limit, for when the duration becomes known.
+func (w *watchdog) setLimit(limit time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.limit = limit
+}
+
+// check returns the reason to kill the conversion at now, or nil.
+func (w *watchdog) check(now time.Time) *TimeoutError {
+	if w.ctl.Suspended() {
+		return nil
+	}
+	paused := w.ctl.SuspendedFor()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if idle := now.Sub(w.lastProgress) - (paused - w.pausedAtProgress); w.stall > 0 && idle > w.stall {
+		return &TimeoutError{Path: w.path, Stalled: true, After: w.stall}
+	}
+	if w.limit > 0 && now.Sub(w.started)-paused > w.limit {
+		return &TimeoutError{Path: w.path, After: w.limit}
+	}
+	return nil
+}
+
+// run checks the conversion every second until ctx is done and cancels it
+// with the *TimeoutError as cause when it should be killed.
+func (w *watchdog) run(ctx context.Context, cancel context.CancelCauseFunc) {
+	if w.stall <= 0 && w.limit <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := w.check(now); err != nil {
+				cancel(err)
+				return
+			}
+		}
+	}
+}
+
+// watch runs fn with a context that is cancelled when the watchdog decides
+// to kill the command fn runs. It returns the *TimeoutError in that case.
+func (w *watchdog) watch(ctx context.Context, fn func(ctx context.Context) error) error {
+	watchCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go w.run(watchCtx, cancel)
+
+	err := fn(watchCtx)
+	var timeout *TimeoutError
+	if err != nil && errors.As(context.Cause(watchCtx), &timeout) {
+		return timeout
+	}
+	return err
+}
+
+// progressWriter collects the stderr of an ffmpeg pass, feeding each line to
+// the watchdog. Progress lines are not kept, so that long passes do not
+// pile up their progress in out.
+type progressWriter struct {
+	dog  *watchdog
+	out  bytes.Buffer
+	line []byte
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	for _, c := range b {
+		if c == '\r' || c == '\n' {
+			p.endLine()
+			continue
+		}
+		p.line = append(p.line, c)
+	}
+	return len(b), nil
+}
+
+// endLine handles the line written so far. It is also called once the
+// command exited, for output that does not end with a newline.
+func (p *progressWriter) endLine() {
+	if len(p.line) == 0 {
+		return
+	}
+	line := string(p.line)
+	p.line = p.line[:0]
+	if !p.dog.observe(line) {
+		p.out.WriteString(line)
+		p.out.WriteByte('\n')
+	}
+}
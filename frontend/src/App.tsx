@@ -4,6 +4,9 @@ import { Layout } from './components/Layout';
 import { DropZone } from './components/DropZone';
 import { FileList } from './components/FileList';
 import { SettingsView } from './components/Settings';
+import { CloseDialog, CloseRequest } from './components/CloseDialog';
+import { BatchSummaryBar } from './components/BatchSummaryBar';
+import { FolderScanDialog, FolderScan } from './components/FolderScanDialog';
 import { AlertCircle, Loader2, UploadCloud } from 'lucide-react';
 import { useTheme } from './hooks/useTheme';
 import { useFileQueue } from './hooks/useFileQueue';
@@ -14,8 +17,10 @@ function App() {
     const [isInstalled, setIsInstalled] = useState<boolean>(true);
     const [isInstalling, setIsInstalling] = useState<boolean>(false);
     const [isDraggingGlobal, setIsDraggingGlobal] = useState(false);
+    const [closeRequest, setCloseRequest] = useState<CloseRequest | null>(null);
+    const [folderScan, setFolderScan] = useState<FolderScan | null>(null);
     const { theme, setTheme } = useTheme();
-    const { files, addFile, handleRemove, handleClearCompleted, handleCopy, isPaused, pauseQueue, resumeQueue } = useFileQueue();
+    const { files, addFile, handleRemove, handleClearCompleted, handleCopy, handleMoveToFront, handleSuspend, handleResume, lastBatch, dismissBatch, isPaused, pauseQueue, resumeQueue } = useFileQueue();
     const installIntervalRef = useRef<ReturnType<typeof setInterval> | null>(null);
     const installTimeoutRef = useRef<ReturnType<typeof setTimeout> | null>(null);
 
@@ -95,6 +100,24 @@ function App() {
         }
     };
 
+    useEffect(() => {
+        return runtime.EventsOn("confirm-close", (request: CloseRequest) => setCloseRequest(request));
+    }, []);
+
+    // Large folders wait for confirmation; smaller ones are queued directly.
+    useEffect(() => {
+        return runtime.EventsOn("folder-scanned", (scan: FolderScan) => {
+            if (scan.error) console.error("Folder scan:", scan.error);
+            if (scan.confirm) setFolderScan(scan);
+        });
+    }, []);
+
+    useEffect(() => {
+        return runtime.EventsOn("folder-scan-expired", (id: string) => {
+            setFolderScan((current) => (current?.id === id ? null : current));
+        });
+    }, []);
+
     useEffect(() => {
         return () => {
             if (installIntervalRef.current) clearInterval(installIntervalRef.current);
@@ -104,6 +127,14 @@ function App() {
 
     return (
         <Layout currentView={view} onNavigate={setView}>
+            {closeRequest && (
+                <CloseDialog request={closeRequest} onDismiss={() => setCloseRequest(null)} />
+            )}
+
+            {folderScan && (
+                <FolderScanDialog scan={folderScan} onDismiss={() => setFolderScan(null)} />
+            )}
+
             {isDraggingGlobal && (
                 <div
                     className="fixed inset-0 z-[100] bg-indigo-500/10 backdrop-blur-sm border-4 border-indigo-500 border-dashed m-4 rounded-2xl flex items-center justify-center animate-in fade-in duration-200 pointer-events-none"
@@ -150,10 +181,18 @@ function App() {
                             isCompact={files.length > 0}
                         />
                     </div>
+                    {lastBatch && (
+                        <div className="shrink-0">
+                            <BatchSummaryBar summary={lastBatch} onDismiss={dismissBatch} />
+                        </div>
+                    )}
                     <div className="flex-1 min-h-0">
                         <FileList
                             files={files}
                             onRemove={handleRemove}
+                            onMoveToFront={handleMoveToFront}
+                            onSuspendJob={handleSuspend}
+                            onResumeJob={handleResume}
                             onCopy={handleCopy}
                             onClearCompleted={handleClearCompleted}
                             isPaused={isPaused}
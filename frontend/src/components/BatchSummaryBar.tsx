@@ -0,0 +1,65 @@
+import React from 'react';
+import { CheckCircle2, X } from 'lucide-react';
+
+export interface BatchSummary {
+    id: string;
+    jobs: number;
+    done: number;
+    failed: number;
+    skipped: number;
+    canceled: number;
+    inputBytes: number;
+    outputBytes: number;
+    elapsed: number; // seconds
+    finished: boolean;
+}
+
+interface BatchSummaryBarProps {
+    summary: BatchSummary;
+    onDismiss: () => void;
+}
+
+const formatBytes = (bytes: number) => {
+    const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+    let i = 0;
+    while (bytes >= 1024 && i < units.length - 1) {
+        bytes /= 1024;
+        i++;
+    }
+    return `${bytes.toFixed(i === 0 ? 0 : 1)} ${units[i]}`;
+};
+
+const formatElapsed = (seconds: number) => {
+    const total = Math.round(seconds);
+    const m = Math.floor(total / 60);
+    const s = total % 60;
+    return m > 0 ? `${m}m ${s}s` : `${s}s`;
+};
+
+export function BatchSummaryBar({ summary, onDismiss }: BatchSummaryBarProps) {
+    const counts = [
+        `${summary.done} done`,
+        summary.failed > 0 && `${summary.failed} failed`,
+        summary.skipped > 0 && `${summary.skipped} skipped`,
+        summary.canceled > 0 && `${summary.canceled} canceled`,
+    ].filter(Boolean).join(', ');
+
+    return (
+        <div className="flex items-center justify-between gap-3 rounded-xl bg-white dark:bg-slate-800/60 px-4 py-2.5 border border-slate-200 dark:border-slate-700/50 shadow-sm text-xs text-slate-600 dark:text-slate-300">
+            <div className="flex items-center gap-2 min-w-0">
+                <CheckCircle2 className={`h-4 w-4 shrink-0 ${summary.failed > 0 ? 'text-red-500' : 'text-emerald-500'}`} />
+                <span className="truncate">
+                    Batch of {summary.jobs} finished in {formatElapsed(summary.elapsed)}: {counts}
+                    {summary.done > 0 && ` · ${formatBytes(summary.inputBytes)} → ${formatBytes(summary.outputBytes)}`}
+                </span>
+            </div>
+            <button
+                onClick={onDismiss}
+                className="p-1 rounded text-slate-400 hover:text-slate-600 dark:hover:text-slate-200 hover:bg-slate-100 dark:hover:bg-slate-700 transition-colors"
+                aria-label="Dismiss batch summary"
+            >
+                <X className="h-3 w-3" />
+            </button>
+        </div>
+    );
+}
@@ -0,0 +1,81 @@
+import React, { useState } from 'react';
+import { Loader2, LogOut } from 'lucide-react';
+import { ConfirmClose } from '../wailsjs/go/main/App';
+
+export interface CloseRequest {
+    running: number;
+    waiting: number;
+}
+
+interface CloseDialogProps {
+    request: CloseRequest;
+    onDismiss: () => void;
+}
+
+export function CloseDialog({ request, onDismiss }: CloseDialogProps) {
+    const [closing, setClosing] = useState<string | null>(null);
+
+    const choose = (choice: 'wait' | 'cancel' | 'persist') => {
+        setClosing(choice);
+        ConfirmClose(choice).catch(err => {
+            console.error(err);
+            setClosing(null);
+        });
+    };
+
+    const buttonClass = "w-full px-4 py-2 text-xs font-semibold rounded-lg transition-colors text-left disabled:opacity-50";
+
+    return (
+        <div className="fixed inset-0 z-[110] bg-slate-900/40 backdrop-blur-sm flex items-center justify-center p-6 animate-in fade-in duration-200">
+            <div className="w-full max-w-sm bg-white dark:bg-slate-800 rounded-xl border border-slate-200 dark:border-slate-700 shadow-xl p-6 space-y-4">
+                <h3 className="text-sm font-semibold text-slate-800 dark:text-slate-200 flex items-center gap-2">
+                    <LogOut className="h-4 w-4 text-indigo-600 dark:text-indigo-400" />
+                    Conversions in progress
+                </h3>
+                <p className="text-xs text-slate-500 dark:text-slate-400">
+                    {request.running} running and {request.waiting} waiting {request.running + request.waiting === 1 ? 'job' : 'jobs'}. What should happen to them?
+                </p>
+
+                {closing === 'wait' ? (
+                    <div className="flex items-center gap-2 text-xs text-slate-600 dark:text-slate-300">
+                        <Loader2 className="w-4 h-4 animate-spin text-indigo-500" />
+                        Closing when the running jobs finish...
+                    </div>
+                ) : (
+                    <div className="space-y-2">
+                        {request.running > 0 && (
+                            <button
+                                onClick={() => choose('wait')}
+                                disabled={closing !== null}
+                                className={`${buttonClass} bg-indigo-600 hover:bg-indigo-700 text-white`}
+                            >
+                                Finish running jobs, save the rest for next launch, then close
+                            </button>
+                        )}
+                        <button
+                            onClick={() => choose('persist')}
+                            disabled={closing !== null}
+                            className={`${buttonClass} bg-slate-100 dark:bg-slate-700/50 hover:bg-slate-200 dark:hover:bg-slate-700 text-slate-700 dark:text-slate-200`}
+                        >
+                            Save the queue for next launch and close
+                        </button>
+                        <button
+                            onClick={() => choose('cancel')}
+                            disabled={closing !== null}
+                            className={`${buttonClass} bg-slate-100 dark:bg-slate-700/50 hover:bg-red-50 dark:hover:bg-red-900/30 text-red-600 dark:text-red-400`}
+                        >
+                            Cancel all jobs and close
+                        </button>
+                        <button
+                            onClick={onDismiss}
+                            disabled={closing !== null}
+                            className={`${buttonClass} text-slate-500 hover:bg-slate-100 dark:hover:bg-slate-700/50`}
+                        >
+                            Keep the window open
+                        </button>
+                    </div>
+                )}
+            </div>
+        </div>
+    );
+}
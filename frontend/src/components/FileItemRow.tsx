@@ -1,21 +1,39 @@
 import React, { memo, useState } from 'react';
-import { FileVideo, FileImage, AlertCircle, CheckCircle2, Loader2, XCircle, Copy, Trash2, Check } from 'lucide-react';
+import { FileVideo, FileImage, AlertCircle, CheckCircle2, Loader2, XCircle, Copy, Trash2, Check, ChevronsUp, Pause, Play } from 'lucide-react';
 import { cn } from '../lib/utils';
 
 export interface FileItem {
-    id: string; // usually path
+    id: string; // job ID assigned by the backend
     path: string;
     destFile?: string;
-    status: 'queued' | 'pending' | 'processing' | 'done' | 'error';
+    status: 'queued' | 'pending' | 'processing' | 'suspended' | 'done' | 'skipped' | 'error';
     progress: number;
     speed?: string;
+    eta?: number; // seconds left
     error?: string;
     thumbnail?: string;
     addedAt?: number;
     completedAt?: number;
 }
 
-export const FileItemRow = memo(({ file, onRemove, onCopy }: { file: FileItem; onRemove: (id: string) => void; onCopy: (path: string) => void }) => {
+interface FileItemRowProps {
+    file: FileItem;
+    onRemove: (id: string) => void;
+    onCopy: (path: string) => void;
+    onMoveToFront?: (id: string) => void;
+    onSuspend?: (id: string) => void;
+    onResume?: (id: string) => void;
+}
+
+const formatEta = (seconds: number) => {
+    const h = Math.floor(seconds / 3600);
+    const m = Math.floor((seconds % 3600) / 60);
+    const s = seconds % 60;
+    const pad = (n: number) => n.toString().padStart(2, '0');
+    return h > 0 ? `${h}:${pad(m)}:${pad(s)}` : `${m}:${pad(s)}`;
+};
+
+export const FileItemRow = memo(({ file, onRemove, onCopy, onMoveToFront, onSuspend, onResume }: FileItemRowProps) => {
     const [isCopied, setIsCopied] = useState(false);
     const [isErrorCopied, setIsErrorCopied] = useState(false);
     const lastSeparatorIndex = Math.max(file.path.lastIndexOf('/'), file.path.lastIndexOf('\\'));
@@ -73,10 +91,12 @@ export const FileItemRow = memo(({ file, onRemove, onCopy }: { file: FileItem; o
                             file.status === 'processing' && "text-blue-600 dark:text-blue-400 bg-blue-50 dark:bg-blue-500/10",
                             file.status === 'pending' && "text-orange-600 dark:text-orange-400 bg-orange-50 dark:bg-orange-500/10",
                             file.status === 'error' && "text-red-600 dark:text-red-400 bg-red-50 dark:bg-red-500/10",
+                            file.status === 'skipped' && "text-slate-600 dark:text-slate-300 bg-slate-100 dark:bg-slate-700/50",
                             file.status === 'queued' && "text-slate-500 bg-slate-100 dark:bg-slate-700/50",
+                            file.status === 'suspended' && "text-amber-600 dark:text-amber-400 bg-amber-50 dark:bg-amber-500/10",
                         )}>
                             {file.status === 'queued' ? 'Waiting' : (file.status === 'pending' ? 'Pending...' : file.status)}
-                            {file.status === 'processing' && file.speed && <span className="normal-case ml-1 opacity-75">({file.speed})</span>}
+                            {file.status === 'processing' && file.speed && <span className="normal-case ml-1 opacity-75">({file.speed}{file.eta ? `, ${formatEta(file.eta)} left` : ''})</span>}
                         </span>
                     </div>
 
@@ -92,7 +112,7 @@ export const FileItemRow = memo(({ file, onRemove, onCopy }: { file: FileItem; o
                             <div
                                 className={cn(
                                     "h-full transition-all duration-300 ease-out rounded-full",
-                                    file.status === 'error' ? "bg-red-500" : (file.status === 'pending' ? "bg-orange-500" : "bg-indigo-500"),
+                                    file.status === 'error' ? "bg-red-500" : (file.status === 'pending' ? "bg-orange-500" : (file.status === 'suspended' ? "bg-amber-500" : "bg-indigo-500")),
                                     file.status === 'done' && "bg-emerald-500"
                                 )}
                                 style={{ width: `${file.progress}%` }}
@@ -118,7 +138,7 @@ export const FileItemRow = memo(({ file, onRemove, onCopy }: { file: FileItem; o
                 </div>
 
                 <div className="shrink-0 flex items-center gap-1 pl-2 border-l border-slate-200 dark:border-white/5">
-                    {file.status === 'done' && file.destFile ? (
+                    {(file.status === 'done' || file.status === 'skipped') && file.destFile ? (
                         <button
                             onClick={handleCopy}
                             className={cn(
@@ -136,6 +156,39 @@ export const FileItemRow = memo(({ file, onRemove, onCopy }: { file: FileItem; o
                         <div className="w-8 h-8" />
                     )}
 
+                    {onSuspend && file.status === 'processing' && (
+                        <button
+                            onClick={() => onSuspend(file.id)}
+                            className="p-2 hover:bg-slate-100 dark:hover:bg-slate-700/50 rounded-lg text-slate-400 hover:text-amber-600 dark:hover:text-amber-400 transition-colors"
+                            title="Suspend"
+                            aria-label="Suspend conversion"
+                        >
+                            <Pause className="w-4 h-4" />
+                        </button>
+                    )}
+
+                    {onResume && file.status === 'suspended' && (
+                        <button
+                            onClick={() => onResume(file.id)}
+                            className="p-2 hover:bg-slate-100 dark:hover:bg-slate-700/50 rounded-lg text-slate-400 hover:text-indigo-600 dark:hover:text-indigo-400 transition-colors"
+                            title="Resume"
+                            aria-label="Resume conversion"
+                        >
+                            <Play className="w-4 h-4" />
+                        </button>
+                    )}
+
+                    {onMoveToFront && (file.status === 'queued' || file.status === 'pending') && (
+                        <button
+                            onClick={() => onMoveToFront(file.id)}
+                            className="p-2 hover:bg-slate-100 dark:hover:bg-slate-700/50 rounded-lg text-slate-400 hover:text-indigo-600 dark:hover:text-indigo-400 transition-colors"
+                            title="Start Next"
+                            aria-label="Move to front of queue"
+                        >
+                            <ChevronsUp className="w-4 h-4" />
+                        </button>
+                    )}
+
                     <button
                         onClick={() => onRemove(file.id)}
                         className="p-2 hover:bg-slate-100 dark:hover:bg-slate-700/50 rounded-lg text-slate-400 hover:text-red-500 dark:hover:text-red-400 transition-colors"
@@ -7,6 +7,9 @@ interface FileListProps {
     onRemove: (id: string) => void;
     onCopy: (path: string) => void;
     onClearCompleted: () => void;
+    onMoveToFront?: (id: string) => void;
+    onSuspendJob?: (id: string) => void;
+    onResumeJob?: (id: string) => void;
     isPaused?: boolean;
     onPause?: () => void;
     onResume?: () => void;
@@ -21,12 +24,13 @@ const Header = ({ title, count, children }: { title: string; count: number; chil
     </div>
 );
 
-export function FileList({ files, onRemove, onCopy, onClearCompleted, isPaused, onPause, onResume }: FileListProps) {
-    const activeFiles = files.filter(f => f.status !== 'done');
+export function FileList({ files, onRemove, onCopy, onClearCompleted, onMoveToFront, onSuspendJob, onResumeJob, isPaused, onPause, onResume }: FileListProps) {
+    const isFinished = (f: FileItem) => f.status === 'done' || f.status === 'skipped';
+    const activeFiles = files.filter(f => !isFinished(f));
     const [sortField, setSortField] = useState<'name' | 'added' | 'completed'>('completed');
     const [sortDirection, setSortDirection] = useState<'asc' | 'desc'>('desc');
 
-    const completedFiles = files.filter(f => f.status === 'done').sort((a, b) => {
+    const completedFiles = files.filter(isFinished).sort((a, b) => {
         let cmp = 0;
         switch (sortField) {
             case 'name':
@@ -58,7 +62,7 @@ export function FileList({ files, onRemove, onCopy, onClearCompleted, isPaused,
                         </button>
                     </Header>
                     {activeFiles.map(file => (
-                        <FileItemRow key={file.id} file={file} onRemove={onRemove} onCopy={onCopy} />
+                        <FileItemRow key={file.id} file={file} onRemove={onRemove} onCopy={onCopy} onMoveToFront={onMoveToFront} onSuspend={onSuspendJob} onResume={onResumeJob} />
                     ))}
                 </div>
              )}
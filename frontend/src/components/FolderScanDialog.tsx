@@ -0,0 +1,63 @@
+import React, { useState } from 'react';
+import { FolderOpen } from 'lucide-react';
+import { ConfirmFolderScan } from '../wailsjs/go/main/App';
+
+export interface FolderScan {
+    id: string;
+    folders: string[];
+    files: number;
+    bytes: number;
+    confirm: boolean;
+    error?: string;
+}
+
+interface FolderScanDialogProps {
+    scan: FolderScan;
+    onDismiss: () => void;
+}
+
+const formatGigabytes = (bytes: number) => `${(bytes / 1024 ** 3).toFixed(1)} GB`;
+
+export function FolderScanDialog({ scan, onDismiss }: FolderScanDialogProps) {
+    const [busy, setBusy] = useState(false);
+
+    const answer = (accept: boolean) => {
+        setBusy(true);
+        ConfirmFolderScan(scan.id, accept).catch(console.error).finally(onDismiss);
+    };
+
+    const buttonClass = "w-full px-4 py-2 text-xs font-semibold rounded-lg transition-colors text-left disabled:opacity-50";
+
+    return (
+        <div className="fixed inset-0 z-[110] bg-slate-900/40 backdrop-blur-sm flex items-center justify-center p-6 animate-in fade-in duration-200">
+            <div className="w-full max-w-sm bg-white dark:bg-slate-800 rounded-xl border border-slate-200 dark:border-slate-700 shadow-xl p-6 space-y-4">
+                <h3 className="text-sm font-semibold text-slate-800 dark:text-slate-200 flex items-center gap-2">
+                    <FolderOpen className="h-4 w-4 text-indigo-600 dark:text-indigo-400" />
+                    Convert {scan.files.toLocaleString()} files?
+                </h3>
+                <p className="text-xs text-slate-500 dark:text-slate-400">
+                    Found {formatGigabytes(scan.bytes)} of photos and videos in {scan.folders.length === 1 ? scan.folders[0] : `${scan.folders.length} folders`}.
+                </p>
+                {scan.error && (
+                    <p className="text-xs text-red-600 dark:text-red-400 break-all">{scan.error}</p>
+                )}
+                <div className="space-y-2">
+                    <button
+                        onClick={() => answer(true)}
+                        disabled={busy}
+                        className={`${buttonClass} bg-indigo-600 hover:bg-indigo-700 text-white`}
+                    >
+                        Convert all
+                    </button>
+                    <button
+                        onClick={() => answer(false)}
+                        disabled={busy}
+                        className={`${buttonClass} text-slate-500 hover:bg-slate-100 dark:hover:bg-slate-700/50`}
+                    >
+                        Cancel
+                    </button>
+                </div>
+            </div>
+        </div>
+    );
+}
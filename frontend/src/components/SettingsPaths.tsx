@@ -1,13 +1,46 @@
-import React from 'react';
-import { FolderOpen } from 'lucide-react';
+import React, { useState } from 'react';
+import { FolderOpen, Plus, Trash2 } from 'lucide-react';
 import { main } from '../wailsjs/go/models';
+import { TestRoute } from '../wailsjs/go/main/App';
 
 interface SettingsPathsProps {
     settings: main.Settings;
     onChange: (settings: main.Settings) => void;
 }
 
+const splitGlobs = (value: string) => value.split(',').map(g => g.trim()).filter(g => g !== '');
+
+const inputClass = "block w-full rounded-lg bg-slate-50 dark:bg-slate-900 border-slate-300 dark:border-slate-700 text-slate-900 dark:text-slate-200 focus:border-indigo-500 focus:ring-1 focus:ring-indigo-500 sm:text-xs px-2 py-1.5 transition-shadow";
+
 export function SettingsPaths({ settings, onChange }: SettingsPathsProps) {
+    const [testPath, setTestPath] = useState('');
+    const [testResult, setTestResult] = useState<main.RouteResult | null>(null);
+
+    const routes = settings.routes || [];
+
+    const updateRoute = (index: number, patch: Partial<main.RouteRule>) => {
+        const next = routes.map((r, i) => (i === index ? { ...r, ...patch } : r));
+        onChange({ ...settings, routes: next as main.RouteRule[] });
+    };
+
+    const addRoute = () => {
+        onChange({ ...settings, routes: [...routes, { name: `Rule ${routes.length + 1}`, glob: '', destDir: '' } as main.RouteRule] });
+    };
+
+    const removeRoute = (index: number) => {
+        onChange({ ...settings, routes: routes.filter((_, i) => i !== index) });
+    };
+
+    const handleTest = async () => {
+        if (!testPath) return;
+        try {
+            // Routes are read from the saved settings.
+            setTestResult(await TestRoute(testPath));
+        } catch (e) {
+            console.error("Error testing route:", e);
+        }
+    };
+
     return (
         <div className="bg-white dark:bg-slate-800/40 rounded-xl p-6 border border-slate-200 dark:border-slate-700/50 hover:border-slate-300 dark:hover:border-slate-600/50 transition-colors shadow-sm dark:shadow-none">
              <h3 className="text-sm font-semibold text-slate-800 dark:text-slate-200 mb-6 flex items-center gap-2">
@@ -36,18 +69,168 @@ export function SettingsPaths({ settings, onChange }: SettingsPathsProps) {
                         <option value="rename">Rename</option>
                         <option value="overwrite">Overwrite</option>
                         <option value="error">Error</option>
+                        <option value="skip">Skip</option>
+                        <option value="skip-if-identical">Skip if already converted</option>
+                        <option value="replace-if-older">Replace if source is newer</option>
                     </select>
                 </div>
                 <div className="space-y-2">
-                    <label htmlFor="paths-exclude" className="text-xs font-medium text-slate-500 dark:text-slate-400">Exclude Patterns (comma separated)</label>
-                     <input
-                        id="paths-exclude"
-                        type="text"
+                    <label htmlFor="paths-content-cache" className="text-xs font-medium text-slate-500 dark:text-slate-400">Reuse Previous Outputs</label>
+                    <select
+                        id="paths-content-cache"
                         className="block w-full rounded-lg bg-slate-50 dark:bg-slate-900 border-slate-300 dark:border-slate-700 text-slate-900 dark:text-slate-200 focus:border-indigo-500 focus:ring-1 focus:ring-indigo-500 sm:text-sm px-3 py-2.5 transition-shadow"
-                        value={settings.excludePatterns?.join(', ')}
-                        onChange={(e) => onChange({ ...settings, excludePatterns: e.target.value.split(',').map(s => s.trim()) })}
-                        placeholder="e.g. \Pictures\, \DCIM\"
-                    />
+                        value={settings.contentCache || "fast"}
+                        onChange={(e) => onChange({ ...settings, contentCache: e.target.value })}
+                    >
+                        <option value="fast">Fast check (file size, start and end)</option>
+                        <option value="full">Full check (hash whole file)</option>
+                        <option value="off">Off (always convert)</option>
+                    </select>
+                </div>
+                <div className="space-y-2">
+                    <span className="text-xs font-medium text-slate-500 dark:text-slate-400">Dropped Folders</span>
+                    <div className="grid grid-cols-2 gap-2">
+                        <input
+                            type="text"
+                            className={`${inputClass} font-mono`}
+                            placeholder="Include globs, e.g. *.mov, DCIM/**"
+                            aria-label="Include globs"
+                            defaultValue={(settings.folderInclude || []).join(', ')}
+                            onBlur={(e) => onChange({ ...settings, folderInclude: splitGlobs(e.target.value) })}
+                        />
+                        <input
+                            type="text"
+                            className={`${inputClass} font-mono`}
+                            placeholder="Exclude globs, e.g. Thumbnails, *_edit.*"
+                            aria-label="Exclude globs"
+                            defaultValue={(settings.folderExclude || []).join(', ')}
+                            onBlur={(e) => onChange({ ...settings, folderExclude: splitGlobs(e.target.value) })}
+                        />
+                        <label className="flex items-center gap-2 text-xs text-slate-500 dark:text-slate-400">
+                            <span className="shrink-0">Max depth</span>
+                            <input
+                                type="number"
+                                min="0"
+                                className={inputClass}
+                                value={settings.folderMaxDepth ?? 0}
+                                onChange={(e) => onChange({ ...settings, folderMaxDepth: parseInt(e.target.value) || 0 })}
+                                placeholder="0 for no limit"
+                            />
+                        </label>
+                        <label className="flex items-center gap-2 text-xs text-slate-500 dark:text-slate-400">
+                            <span className="shrink-0">Ask above</span>
+                            <input
+                                type="number"
+                                min="0"
+                                className={inputClass}
+                                value={settings.folderConfirmCount ?? 100}
+                                onChange={(e) => onChange({ ...settings, folderConfirmCount: parseInt(e.target.value) || 0 })}
+                                placeholder="0 never asks"
+                            />
+                            <span className="shrink-0">files</span>
+                        </label>
+                        <select
+                            className={inputClass}
+                            aria-label="Hidden files"
+                            value={settings.folderSkipHidden ? 'skip' : 'include'}
+                            onChange={(e) => onChange({ ...settings, folderSkipHidden: e.target.value === 'skip' })}
+                        >
+                            <option value="skip">Skip hidden files</option>
+                            <option value="include">Include hidden files</option>
+                        </select>
+                        <select
+                            className={inputClass}
+                            aria-label="Symbolic links"
+                            value={settings.folderSymlinks || 'skip'}
+                            onChange={(e) => onChange({ ...settings, folderSymlinks: e.target.value })}
+                        >
+                            <option value="skip">Skip symbolic links</option>
+                            <option value="follow">Follow symbolic links</option>
+                        </select>
+                        <select
+                            className={`${inputClass} col-span-2`}
+                            aria-label="Folder structure"
+                            value={settings.mirrorFolders ? 'mirror' : 'flat'}
+                            onChange={(e) => onChange({ ...settings, mirrorFolders: e.target.value === 'mirror' })}
+                        >
+                            <option value="flat">Put all outputs in the destination folder</option>
+                            <option value="mirror">Mirror the folder structure under the destination</option>
+                        </select>
+                    </div>
+                </div>
+                <div className="space-y-2">
+                    <label htmlFor="paths-source-action" className="text-xs font-medium text-slate-500 dark:text-slate-400">After Conversion (original file)</label>
+                    <div className="flex gap-2">
+                        <select
+                            id="paths-source-action"
+                            className="block w-full rounded-lg bg-slate-50 dark:bg-slate-900 border-slate-300 dark:border-slate-700 text-slate-900 dark:text-slate-200 focus:border-indigo-500 focus:ring-1 focus:ring-indigo-500 sm:text-sm px-3 py-2.5 transition-shadow"
+                            value={settings.sourceAction || "none"}
+                            onChange={(e) => onChange({ ...settings, sourceAction: e.target.value })}
+                        >
+                            <option value="none">Keep</option>
+                            <option value="trash">Move to Trash</option>
+                            <option value="archive">Move to Archive Folder</option>
+                            <option value="delete">Delete</option>
+                        </select>
+                        {settings.sourceAction === 'archive' && (
+                            <input
+                                type="text"
+                                className="block w-full rounded-lg bg-slate-50 dark:bg-slate-900 border-slate-300 dark:border-slate-700 text-slate-900 dark:text-slate-200 focus:border-indigo-500 focus:ring-1 focus:ring-indigo-500 sm:text-xs px-3 py-2.5 font-mono transition-shadow"
+                                placeholder="Archive folder"
+                                value={settings.archiveDir}
+                                onChange={(e) => onChange({ ...settings, archiveDir: e.target.value })}
+                            />
+                        )}
+                    </div>
+                </div>
+                <div className="space-y-2">
+                    <div className="flex items-center justify-between">
+                        <span className="text-xs font-medium text-slate-500 dark:text-slate-400">Routing Rules (first match wins)</span>
+                        <button
+                            onClick={addRoute}
+                            className="text-xs flex items-center gap-1 px-2 py-1 bg-slate-100 dark:bg-slate-800 hover:bg-slate-200 dark:hover:bg-slate-700 rounded-lg border border-slate-300 dark:border-slate-700/50 text-slate-600 dark:text-slate-300 transition-colors"
+                        >
+                            <Plus className="w-3 h-3" /> Add Rule
+                        </button>
+                    </div>
+                    {routes.map((r, i) => (
+                        <div key={i} className="grid grid-cols-12 gap-2 items-center">
+                            <input className={`${inputClass} col-span-2`} placeholder="Name" value={r.name} onChange={(e) => updateRoute(i, { name: e.target.value })} />
+                            <input className={`${inputClass} col-span-3 font-mono`} placeholder="**/DCIM/**" value={r.glob || ''} onChange={(e) => updateRoute(i, { glob: e.target.value })} />
+                            <select className={`${inputClass} col-span-2`} value={r.mediaType || ''} onChange={(e) => updateRoute(i, { mediaType: e.target.value })}>
+                                <option value="">Any</option>
+                                <option value="video">Video</option>
+                                <option value="image">Image</option>
+                            </select>
+                            <input className={`${inputClass} col-span-3 font-mono`} placeholder="Destination (default)" value={r.destDir || ''} onChange={(e) => updateRoute(i, { destDir: e.target.value })} />
+                            <input className={`${inputClass} col-span-1`} placeholder="Preset" value={r.preset || ''} onChange={(e) => updateRoute(i, { preset: e.target.value })} />
+                            <button onClick={() => removeRoute(i)} className="col-span-1 flex justify-center text-slate-400 hover:text-red-500 transition-colors" title="Remove rule">
+                                <Trash2 className="w-3.5 h-3.5" />
+                            </button>
+                        </div>
+                    ))}
+                    <div className="flex gap-2 pt-1">
+                        <input
+                            id="paths-route-test"
+                            type="text"
+                            className={`${inputClass} font-mono`}
+                            placeholder="Test a path against the saved rules"
+                            value={testPath}
+                            onChange={(e) => setTestPath(e.target.value)}
+                        />
+                        <button
+                            onClick={handleTest}
+                            className="text-xs px-3 py-1.5 bg-slate-100 dark:bg-slate-800 hover:bg-slate-200 dark:hover:bg-slate-700 rounded-lg border border-slate-300 dark:border-slate-700/50 text-slate-600 dark:text-slate-300 transition-colors"
+                        >
+                            Test
+                        </button>
+                    </div>
+                    {testResult && (
+                        <p className="text-xs text-slate-500 dark:text-slate-400 font-mono break-all">
+                            {testResult.rule < 0 ? 'No rule matches' : `Rule "${testResult.name}"`} → {testResult.destDir}
+                            {testResult.preset ? ` (preset ${testResult.preset})` : ''}
+                        </p>
+                    )}
                 </div>
              </div>
         </div>
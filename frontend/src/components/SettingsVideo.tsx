@@ -49,7 +49,7 @@ export function SettingsVideo({ settings, onChange }: SettingsVideoProps) {
                 </div>
 
                 <div className="space-y-2">
-                    <label htmlFor="video-workers" className="text-xs font-medium text-slate-500 dark:text-slate-400">Concurrent Jobs</label>
+                    <label htmlFor="video-workers" className="text-xs font-medium text-slate-500 dark:text-slate-400" title="A Full HD encode weighs 1 unit, a 4K encode 4. A video heavier than the limit runs on its own.">Concurrent Work (Full HD units)</label>
                     <input
                         id="video-workers"
                         type="number"
@@ -60,6 +60,166 @@ export function SettingsVideo({ settings, onChange }: SettingsVideoProps) {
                     />
                 </div>
 
+                <div className="space-y-2">
+                    <label htmlFor="cpu-budget" className="text-xs font-medium text-slate-500 dark:text-slate-400">CPU Budget (all jobs)</label>
+                    <input
+                        id="cpu-budget"
+                        type="number"
+                        min="0"
+                        className="block w-full rounded-lg bg-slate-50 dark:bg-slate-900 border-slate-300 dark:border-slate-700 text-slate-900 dark:text-slate-200 focus:border-indigo-500 focus:ring-1 focus:ring-indigo-500 sm:text-sm px-3 py-2.5 transition-shadow"
+                        value={settings.cpuBudget || 0}
+                        onChange={(e) => onChange({ ...settings, cpuBudget: parseInt(e.target.value) || 0 })}
+                        placeholder="0 for no limit"
+                    />
+                </div>
+
+                <div className="space-y-2">
+                    <label htmlFor="resource-mode" className="text-xs font-medium text-slate-500 dark:text-slate-400">Resource Usage</label>
+                    <select
+                        id="resource-mode"
+                        className="block w-full rounded-lg bg-slate-50 dark:bg-slate-900 border-slate-300 dark:border-slate-700 text-slate-900 dark:text-slate-200 focus:border-indigo-500 focus:ring-1 focus:ring-indigo-500 sm:text-sm px-3 py-2.5 transition-shadow"
+                        value={settings.resourceMode || "fast"}
+                        onChange={(e) => onChange({ ...settings, resourceMode: e.target.value })}
+                    >
+                        <option value="fast">Fast (normal priority, no limits)</option>
+                        <option value="background">Background (low priority, half the cores)</option>
+                        <option value="custom">Custom</option>
+                    </select>
+                </div>
+
+                {settings.resourceMode === 'custom' && (
+                    <>
+                        <div className="space-y-2">
+                            <label htmlFor="process-priority" className="text-xs font-medium text-slate-500 dark:text-slate-400">Process Priority</label>
+                            <select
+                                id="process-priority"
+                                className="block w-full rounded-lg bg-slate-50 dark:bg-slate-900 border-slate-300 dark:border-slate-700 text-slate-900 dark:text-slate-200 focus:border-indigo-500 focus:ring-1 focus:ring-indigo-500 sm:text-sm px-3 py-2.5 transition-shadow"
+                                value={settings.processPriority || "normal"}
+                                onChange={(e) => onChange({ ...settings, processPriority: e.target.value })}
+                            >
+                                <option value="normal">Normal</option>
+                                <option value="low">Low</option>
+                            </select>
+                        </div>
+
+                        <div className="space-y-2">
+                            <label htmlFor="ffmpeg-threads" className="text-xs font-medium text-slate-500 dark:text-slate-400">FFmpeg Threads</label>
+                            <input
+                                id="ffmpeg-threads"
+                                type="number"
+                                min="0"
+                                className="block w-full rounded-lg bg-slate-50 dark:bg-slate-900 border-slate-300 dark:border-slate-700 text-slate-900 dark:text-slate-200 focus:border-indigo-500 focus:ring-1 focus:ring-indigo-500 sm:text-sm px-3 py-2.5 transition-shadow"
+                                value={settings.ffmpegThreads || 0}
+                                onChange={(e) => onChange({ ...settings, ffmpegThreads: parseInt(e.target.value) || 0 })}
+                                placeholder="0 for automatic"
+                            />
+                        </div>
+
+                        <div className="space-y-2">
+                            <label htmlFor="magick-threads" className="text-xs font-medium text-slate-500 dark:text-slate-400">ImageMagick Threads</label>
+                            <input
+                                id="magick-threads"
+                                type="number"
+                                min="0"
+                                className="block w-full rounded-lg bg-slate-50 dark:bg-slate-900 border-slate-300 dark:border-slate-700 text-slate-900 dark:text-slate-200 focus:border-indigo-500 focus:ring-1 focus:ring-indigo-500 sm:text-sm px-3 py-2.5 transition-shadow"
+                                value={settings.magickThreads || 0}
+                                onChange={(e) => onChange({ ...settings, magickThreads: parseInt(e.target.value) || 0 })}
+                                placeholder="0 for automatic"
+                            />
+                        </div>
+
+                        <div className="space-y-2">
+                            <label htmlFor="magick-memory" className="text-xs font-medium text-slate-500 dark:text-slate-400">ImageMagick Memory Limit</label>
+                            <input
+                                id="magick-memory"
+                                type="text"
+                                className="block w-full rounded-lg bg-slate-50 dark:bg-slate-900 border-slate-300 dark:border-slate-700 text-slate-900 dark:text-slate-200 focus:border-indigo-500 focus:ring-1 focus:ring-indigo-500 sm:text-sm px-3 py-2.5 transition-shadow"
+                                value={settings.magickMemoryLimit || ""}
+                                onChange={(e) => onChange({ ...settings, magickMemoryLimit: e.target.value })}
+                                placeholder="e.g. 1GiB"
+                            />
+                        </div>
+
+                        <div className="space-y-2">
+                            <label htmlFor="magick-disk" className="text-xs font-medium text-slate-500 dark:text-slate-400">ImageMagick Disk Limit</label>
+                            <input
+                                id="magick-disk"
+                                type="text"
+                                className="block w-full rounded-lg bg-slate-50 dark:bg-slate-900 border-slate-300 dark:border-slate-700 text-slate-900 dark:text-slate-200 focus:border-indigo-500 focus:ring-1 focus:ring-indigo-500 sm:text-sm px-3 py-2.5 transition-shadow"
+                                value={settings.magickDiskLimit || ""}
+                                onChange={(e) => onChange({ ...settings, magickDiskLimit: e.target.value })}
+                                placeholder="e.g. 4GiB"
+                            />
+                        </div>
+                    </>
+                )}
+
+                <div className="space-y-2">
+                    <label htmlFor="stall-timeout" className="text-xs font-medium text-slate-500 dark:text-slate-400">Stall Timeout (seconds)</label>
+                    <input
+                        id="stall-timeout"
+                        type="number"
+                        min="0"
+                        className="block w-full rounded-lg bg-slate-50 dark:bg-slate-900 border-slate-300 dark:border-slate-700 text-slate-900 dark:text-slate-200 focus:border-indigo-500 focus:ring-1 focus:ring-indigo-500 sm:text-sm px-3 py-2.5 transition-shadow"
+                        value={settings.stallTimeout ?? 120}
+                        onChange={(e) => onChange({ ...settings, stallTimeout: parseFloat(e.target.value) || 0 })}
+                        placeholder="0 to disable"
+                    />
+                </div>
+
+                <div className="space-y-2">
+                    <label htmlFor="timeout-factor" className="text-xs font-medium text-slate-500 dark:text-slate-400">Time Limit (x video duration)</label>
+                    <input
+                        id="timeout-factor"
+                        type="number"
+                        min="0"
+                        step="0.5"
+                        className="block w-full rounded-lg bg-slate-50 dark:bg-slate-900 border-slate-300 dark:border-slate-700 text-slate-900 dark:text-slate-200 focus:border-indigo-500 focus:ring-1 focus:ring-indigo-500 sm:text-sm px-3 py-2.5 transition-shadow"
+                        value={settings.timeoutFactor ?? 10}
+                        onChange={(e) => onChange({ ...settings, timeoutFactor: parseFloat(e.target.value) || 0 })}
+                        placeholder="0 to disable"
+                    />
+                </div>
+
+                <div className="space-y-2">
+                    <label htmlFor="max-retries" className="text-xs font-medium text-slate-500 dark:text-slate-400">Retries After Timeout</label>
+                    <input
+                        id="max-retries"
+                        type="number"
+                        min="0"
+                        className="block w-full rounded-lg bg-slate-50 dark:bg-slate-900 border-slate-300 dark:border-slate-700 text-slate-900 dark:text-slate-200 focus:border-indigo-500 focus:ring-1 focus:ring-indigo-500 sm:text-sm px-3 py-2.5 transition-shadow"
+                        value={settings.maxRetries ?? 1}
+                        onChange={(e) => onChange({ ...settings, maxRetries: parseInt(e.target.value) || 0 })}
+                    />
+                </div>
+
+                <div className="space-y-2">
+                    <label htmlFor="queue-order" className="text-xs font-medium text-slate-500 dark:text-slate-400">Queue Order</label>
+                    <select
+                        id="queue-order"
+                        className="block w-full rounded-lg bg-slate-50 dark:bg-slate-900 border-slate-300 dark:border-slate-700 text-slate-900 dark:text-slate-200 focus:border-indigo-500 focus:ring-1 focus:ring-indigo-500 sm:text-sm px-3 py-2.5 transition-shadow"
+                        value={settings.queueOrder || "priority"}
+                        onChange={(e) => onChange({ ...settings, queueOrder: e.target.value })}
+                    >
+                        <option value="priority">Images first, long videos last</option>
+                        <option value="fifo">As added</option>
+                    </select>
+                </div>
+
+                <div className="space-y-2">
+                    <label htmlFor="long-video-minutes" className="text-xs font-medium text-slate-500 dark:text-slate-400">Long Video (minutes)</label>
+                    <input
+                        id="long-video-minutes"
+                        type="number"
+                        min="0"
+                        className="block w-full rounded-lg bg-slate-50 dark:bg-slate-900 border-slate-300 dark:border-slate-700 text-slate-900 dark:text-slate-200 focus:border-indigo-500 focus:ring-1 focus:ring-indigo-500 sm:text-sm px-3 py-2.5 transition-shadow"
+                        value={settings.longVideoMinutes ?? 10}
+                        onChange={(e) => onChange({ ...settings, longVideoMinutes: parseFloat(e.target.value) || 0 })}
+                        disabled={settings.queueOrder === 'fifo'}
+                        placeholder="0 to disable"
+                    />
+                </div>
+
                 <div className="space-y-2">
                     <label htmlFor="video-max-size" className="text-xs font-medium text-slate-500 dark:text-slate-400">Max Resolution (Size)</label>
                      <input
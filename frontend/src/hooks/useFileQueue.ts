@@ -1,46 +1,90 @@
 import { useState, useCallback, useRef, useEffect } from 'react';
 import { EventsOn, EventsEmit } from '../wailsjs/runtime/runtime';
-import { ConvertFiles, GetThumbnail, CancelJob, PauseQueue, ResumeQueue, CopyFileToClipboard } from '../wailsjs/go/main/App';
+import { ConvertFiles, GetThumbnail, GetJobs, CancelJob, ClearCompletedJobs, MoveJob, SuspendJob, ResumeJob, PauseQueue, ResumeQueue, CopyFileToClipboard } from '../wailsjs/go/main/App';
 import { FileItem } from '../components/FileItemRow';
+import { BatchSummary } from '../components/BatchSummaryBar';
 
 interface ProgressData {
+    id: string;
     file: string;
     destFile?: string;
-    status: 'queued' | 'pending' | 'processing' | 'done' | 'error';
+    status: 'queued' | 'pending' | 'processing' | 'suspended' | 'done' | 'skipped' | 'error';
     progress: number;
     speed?: string;
+    eta?: number;
     error?: string;
+    batch: string;
 }
 
+const isFinished = (status: FileItem['status']) => status === 'done' || status === 'skipped';
+
 export function useFileQueue() {
     const [files, setFiles] = useState<FileItem[]>([]);
     const [isPaused, setIsPaused] = useState<boolean>(false);
+    const [lastBatch, setLastBatch] = useState<BatchSummary | null>(null);
     const filesRef = useRef(files);
     filesRef.current = files;
+    const pendingRef = useRef<string[]>([]);
+    const submitTimer = useRef<ReturnType<typeof setTimeout>>();
 
-    const addFile = useCallback((path: string) => {
-        // Prevent redundant thumbnail requests by checking against the current files ref
-        if (filesRef.current.some(f => f.path === path)) return;
-
-        setFiles(prev => {
-            if (prev.some(f => f.path === path)) return prev;
-            return [...prev, { id: path, path, status: 'queued', progress: 0, addedAt: Date.now() }];
-        });
-
+    const loadThumbnail = useCallback((id: string, path: string) => {
         GetThumbnail(path).then(thumb => {
-            setFiles(prev => prev.map(f => f.path === path ? { ...f, thumbnail: thumb } : f));
+            setFiles(prev => prev.map(f => f.id === id ? { ...f, thumbnail: thumb } : f));
         }).catch(err => {
             console.error("Failed to load thumbnail for", path, err);
         });
     }, []);
 
+    // Jobs are created by the backend, which announces each one with a
+    // "job-queued" event before any progress for it.
+    const addJob = useCallback((job: ProgressData) => {
+        setFiles(prev => {
+            if (prev.some(f => f.id === job.id)) return prev;
+            return [...prev, { id: job.id, path: job.file, status: job.status, progress: job.progress, destFile: job.destFile, error: job.error, addedAt: Date.now() }];
+        });
+        loadThumbnail(job.id, job.file);
+    }, [loadThumbnail]);
+
+    const addFile = useCallback((path: string) => {
+        // Ignore drops of a file that is still being converted; the backend
+        // accepts duplicates, e.g. for converting with different presets.
+        if (filesRef.current.some(f => f.path === path && !isFinished(f.status) && f.status !== 'error')) return;
+        if (pendingRef.current.includes(path)) return;
+
+        pendingRef.current.push(path);
+        clearTimeout(submitTimer.current);
+        submitTimer.current = setTimeout(() => {
+            const paths = pendingRef.current;
+            pendingRef.current = [];
+            ConvertFiles(paths).catch(console.error);
+        }, 100);
+    }, []);
+
     const handleRemove = useCallback((id: string) => {
         CancelJob(id);
         setFiles(prev => prev.filter(f => f.id !== id));
     }, []);
 
     const handleClearCompleted = useCallback(() => {
-        setFiles(prev => prev.filter(f => f.status !== 'done'));
+        ClearCompletedJobs();
+        setFiles(prev => prev.filter(f => !isFinished(f.status)));
+    }, []);
+
+    // Moving a job changes the order of the waiting jobs, so the list is
+    // reordered to match the backend.
+    const handleMoveToFront = useCallback((id: string) => {
+        MoveJob(id, 0).then(() => GetJobs()).then(jobs => {
+            const position = new Map(jobs.map((job, i) => [job.id, i]));
+            setFiles(prev => [...prev].sort((a, b) => (position.get(a.id) ?? prev.length) - (position.get(b.id) ?? prev.length)));
+        }).catch(console.error);
+    }, []);
+
+    const handleSuspend = useCallback((id: string) => {
+        SuspendJob(id).catch(console.error);
+    }, []);
+
+    const handleResume = useCallback((id: string) => {
+        ResumeJob(id).catch(console.error);
     }, []);
 
     const handleCopy = useCallback((path: string) => {
@@ -48,6 +92,8 @@ export function useFileQueue() {
     }, []);
 
     useEffect(() => {
+        GetJobs().then(jobs => jobs.forEach(job => addJob(job as ProgressData))).catch(console.error);
+
         const cleanupFileAdded = EventsOn("file-added", (path: string) => {
             addFile(path);
         });
@@ -56,16 +102,21 @@ export function useFileQueue() {
              paths.forEach(addFile);
         });
 
+        const cleanupJobQueued = EventsOn("job-queued", (data: ProgressData) => {
+            addJob(data);
+        });
+
         const cleanupProgress = EventsOn("conversion-progress", (data: ProgressData) => {
             setFiles(prev => prev.map(f => {
-                if (f.id === data.file) {
+                if (f.id === data.id) {
                     const now = Date.now();
-                    const isDone = data.status === 'done';
+                    const isDone = isFinished(data.status);
                     return {
                         ...f,
                         status: data.status,
                         progress: data.progress,
                         speed: data.speed,
+                        eta: data.eta,
                         error: data.error,
                         destFile: data.destFile,
                         completedAt: isDone && !f.completedAt ? now : f.completedAt
@@ -75,6 +126,12 @@ export function useFileQueue() {
             }));
         });
 
+        // Batches submitted one after another overlap; the summary of the
+        // one that finished last is shown.
+        const cleanupBatch = EventsOn("batch-finished", (summary: BatchSummary) => {
+            if (summary.jobs > 0) setLastBatch(summary);
+        });
+
         const cleanupPaused = EventsOn("queue-paused", () => setIsPaused(true));
         const cleanupResumed = EventsOn("queue-resumed", () => setIsPaused(false));
 
@@ -83,26 +140,13 @@ export function useFileQueue() {
         return () => {
             cleanupFileAdded();
             cleanupFilesReceived();
+            cleanupJobQueued();
             cleanupProgress();
+            cleanupBatch();
             cleanupPaused();
             cleanupResumed();
         };
-    }, [addFile]);
-
-    const queuedCount = files.filter(f => f.status === 'queued').length;
-    useEffect(() => {
-        const queued = files.filter(f => f.status === 'queued');
-        if (queued.length > 0) {
-            const timeout = setTimeout(() => {
-                const queuedPaths = queued.map(f => f.path);
-                setFiles(prev => prev.map(f => queuedPaths.includes(f.path) ? { ...f, status: 'processing', progress: 0 } : f));
-
-                ConvertFiles(queuedPaths);
-            }, 100);
-            return () => clearTimeout(timeout);
-        }
-    // eslint-disable-next-line react-hooks/exhaustive-deps
-    }, [queuedCount]);
+    }, [addFile, addJob]);
 
     return {
         files,
@@ -110,6 +154,11 @@ export function useFileQueue() {
         handleRemove,
         handleClearCompleted,
         handleCopy,
+        handleMoveToFront,
+        handleSuspend,
+        handleResume,
+        lastBatch,
+        dismissBatch: () => setLastBatch(null),
         isPaused,
         pauseQueue: PauseQueue,
         resumeQueue: ResumeQueue
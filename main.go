@@ -80,21 +80,25 @@ func main() {
 		logger.Error("Error getting executable path", "error", err)
 	}
 
-	if len(os.Args) > 1 {
-		for _, arg := range os.Args[1:] {
-			if exePath != "" {
-				if absArg, err := filepath.Abs(arg); err == nil && strings.EqualFold(absArg, exePath) {
-					logger.Info("Skipping executable path in args", "arg", arg)
-					continue
-				}
-			}
+	requests, err := parseLaunchArgs(os.Args[1:])
+	if err != nil {
+		logger.Error("Skipping invalid command line options", "error", err)
+	}
 
-			if absArg, err := filepath.Abs(arg); err == nil {
-				app.pendingFiles = append(app.pendingFiles, absArg)
-			} else {
-				app.pendingFiles = append(app.pendingFiles, arg)
+	for _, req := range requests {
+		arg := req.File
+		if exePath != "" {
+			if absArg, err := filepath.Abs(arg); err == nil && strings.EqualFold(absArg, exePath) {
+				logger.Info("Skipping executable path in args", "arg", arg)
+				continue
 			}
 		}
+
+		if absArg, err := filepath.Abs(arg); err == nil {
+			arg = absArg
+		}
+		app.pendingFiles = append(app.pendingFiles, arg)
+		app.launchOptions[arg] = req.Options
 	}
 
 	err = wails.Run(&options.App{
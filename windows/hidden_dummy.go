@@ -0,0 +1,9 @@
+//go:build !windows
+
+package windows
+
+// IsHidden reports whether the file has the hidden attribute set. Other
+// systems have no such attribute; dot files are hidden by name.
+func IsHidden(path string) bool {
+	return false
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package windows
+
+import "syscall"
+
+// IsHidden reports whether the file has the hidden attribute set.
+func IsHidden(path string) bool {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return false
+	}
+	attrs, err := syscall.GetFileAttributes(p)
+	if err != nil {
+		return false
+	}
+	return attrs&syscall.FILE_ATTRIBUTE_HIDDEN != 0
+}
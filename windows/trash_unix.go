@@ -0,0 +1,160 @@
+//go:build !windows
+
+package windows
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// MoveToTrash moves the file to the user's trash: ~/.Trash on macOS and the
+// freedesktop.org trash elsewhere, so file managers can restore it.
+func MoveToTrash(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+
+	if runtime.GOOS == "darwin" {
+		dir := filepath.Join(home, ".Trash")
+		for i := 0; ; i++ {
+			dst := filepath.Join(dir, trashName(filepath.Base(path), i))
+			if _, err := os.Lstat(dst); os.IsNotExist(err) {
+				return os.Rename(path, dst)
+			}
+		}
+	}
+
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	homeTrash := filepath.Join(dataHome, "Trash")
+
+	if err := os.MkdirAll(homeTrash, 0700); err != nil {
+		return err
+	}
+
+	// The home trash is only used for files on the same device; others go to
+	// the trash at the top of their mount point, with a relative path.
+	if sameDevice(path, homeTrash) {
+		return trashInto(homeTrash, path, path)
+	}
+
+	top := mountPoint(path)
+	trash, err := topdirTrash(top)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(top, path)
+	if err != nil {
+		return err
+	}
+	return trashInto(trash, path, rel)
+}
+
+// trashInto claims a name by creating its .trashinfo file, then moves the
+// file into the trash's files directory.
+func trashInto(trash, path, infoPath string) error {
+	filesDir := filepath.Join(trash, "files")
+	infoDir := filepath.Join(trash, "info")
+	for _, d := range []string{filesDir, infoDir} {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			return err
+		}
+	}
+
+	info := fmt.Sprintf("[Trash Info]\nPath=%s\nDeletionDate=%s\n",
+		(&url.URL{Path: filepath.ToSlash(infoPath)}).EscapedPath(),
+		time.Now().Format("2006-01-02T15:04:05"))
+
+	for i := 0; ; i++ {
+		name := trashName(filepath.Base(path), i)
+		infoFile := filepath.Join(infoDir, name+".trashinfo")
+		f, err := os.OpenFile(infoFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteString(info)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(path, filepath.Join(filesDir, name))
+		}
+		if err != nil {
+			os.Remove(infoFile)
+		}
+		return err
+	}
+}
+
+// trashName returns the n-th candidate name, e.g. "a.mov", "a.2.mov".
+func trashName(name string, n int) string {
+	if n == 0 {
+		return name
+	}
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(name, ext), n+1, ext)
+}
+
+// topdirTrash returns $topdir/.Trash/$uid when the administrator prepared a
+// sticky, non-symlink .Trash, and $topdir/.Trash-$uid otherwise.
+func topdirTrash(top string) (string, error) {
+	uid := os.Getuid()
+	shared := filepath.Join(top, ".Trash")
+	if fi, err := os.Lstat(shared); err == nil && fi.IsDir() && fi.Mode()&os.ModeSticky != 0 {
+		dir := filepath.Join(shared, fmt.Sprint(uid))
+		if err := os.MkdirAll(dir, 0700); err == nil {
+			return dir, nil
+		}
+	}
+	dir := filepath.Join(top, fmt.Sprintf(".Trash-%d", uid))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("could not create trash on %s: %w", top, err)
+	}
+	return dir, nil
+}
+
+func device(path string) (uint64, bool) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Dev), true
+}
+
+func sameDevice(a, b string) bool {
+	da, ok1 := device(a)
+	db, ok2 := device(b)
+	return ok1 && ok2 && da == db
+}
+
+// mountPoint walks up from path to the topmost directory on the same device.
+func mountPoint(path string) string {
+	dir := filepath.Dir(path)
+	for {
+		parent := filepath.Dir(dir)
+		if parent == dir || !sameDevice(parent, dir) {
+			return dir
+		}
+		dir = parent
+	}
+}
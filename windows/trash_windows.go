@@ -0,0 +1,23 @@
+//go:build windows
+
+package windows
+
+import (
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// MoveToTrash moves the file to the Recycle Bin.
+func MoveToTrash(path string) error {
+	// Escape single quotes for PowerShell
+	escapedPath := strings.ReplaceAll(path, "'", "''")
+
+	cmdStr := `Add-Type -AssemblyName Microsoft.VisualBasic; [Microsoft.VisualBasic.FileIO.FileSystem]::DeleteFile('` + escapedPath + `', 'OnlyErrorDialogs', 'SendToRecycleBin')`
+	cmd := exec.Command("powershell", "-NoProfile", "-Command", cmdStr)
+
+	// Hide the console window
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+
+	return cmd.Run()
+}